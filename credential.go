@@ -0,0 +1,72 @@
+package judb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CredentialProvider 提供数据库密码. 通过 Db.SetCredentialProvider 设置后, 连接池每次新建连接时都会调用它获取密码,
+// 适用于定期轮换的密码和有效期很短的云数据库 IAM 令牌. 实现必须支持并发调用.
+type CredentialProvider interface {
+	Password(ctx context.Context) (string, error)
+}
+
+// CredentialFunc 把普通函数转换为 CredentialProvider, 方便测试或者接入自定义的密钥服务
+type CredentialFunc func(ctx context.Context) (string, error)
+
+func (f CredentialFunc) Password(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// EnvCredential 从环境变量读取密码, 值是环境变量的名称, 例如 EnvCredential("DB_PASSWORD")
+type EnvCredential string
+
+func (name EnvCredential) Password(context.Context) (string, error) {
+	val, ok := os.LookupEnv(string(name))
+	if !ok {
+		return "", fmt.Errorf("环境变量 %s 不存在", string(name))
+	}
+	return val, nil
+}
+
+// FileCredential 从文件读取密码, 首尾的空白字符会被去掉. 文件的修改时间或大小变化后重新读取, 否则使用缓存的密码,
+// 适用于 Kubernetes Secret 或者 Vault Agent 写入磁盘的密码文件.
+type FileCredential struct {
+	path     string
+	mutex    sync.Mutex
+	stamp    fileStamp
+	password string
+}
+
+func NewFileCredential(path string) *FileCredential {
+	return &FileCredential{path: path}
+}
+
+func (fc *FileCredential) Password(context.Context) (string, error) {
+	info, err := os.Stat(fc.path)
+	if err != nil {
+		return "", err
+	}
+	stamp := fileStamp{modTime: info.ModTime(), size: info.Size()}
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if stamp == fc.stamp {
+		return fc.password, nil
+	}
+	data, err := os.ReadFile(fc.path)
+	if err != nil {
+		return "", err
+	}
+	fc.password = strings.TrimSpace(string(data))
+	fc.stamp = stamp
+	return fc.password, nil
+}
+
+// SetCredentialProvider 设置密码提供者, 必须在 OpenMysql 或 OpenPostgres 之前调用, 设置后配置中的密码被忽略.
+// SQLite 没有密码, 这个设置对它无效.
+func (db *Db) SetCredentialProvider(provider CredentialProvider) {
+	db.credential = provider
+}
@@ -0,0 +1,117 @@
+package judb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jsuserapp/judb/postgres"
+)
+
+func TestEnvCredential(t *testing.T) {
+	t.Setenv("JUDB_TEST_PASSWORD", "secret")
+	if pass, err := EnvCredential("JUDB_TEST_PASSWORD").Password(context.Background()); err != nil || pass != "secret" {
+		t.Fatalf("Password = %q, %v", pass, err)
+	}
+	if _, err := EnvCredential("JUDB_TEST_MISSING_PASSWORD").Password(context.Background()); err == nil {
+		t.Fatal("missing variable returned no error")
+	}
+}
+
+func TestFileCredential(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	ctx := context.Background()
+	fc := NewFileCredential(file)
+	if _, err := fc.Password(ctx); err == nil {
+		t.Fatal("missing file returned no error")
+	}
+	if err := os.WriteFile(file, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if pass, err := fc.Password(ctx); err != nil || pass != "first" {
+		t.Fatalf("Password = %q, %v", pass, err)
+	}
+	// 轮换后的密码在文件的修改时间或大小变化后生效
+	if err := os.WriteFile(file, []byte("  rotated  \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if pass, err := fc.Password(ctx); err != nil || pass != "rotated" {
+		t.Fatalf("Password after rotation = %q, %v", pass, err)
+	}
+}
+
+// closingListener 接受连接后立即关闭, 驱动在连接前调用密码提供者, 所以不需要真正的数据库服务器
+func closingListener(t *testing.T) (host string, port int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestCredentialProviderPerConnection(t *testing.T) {
+	host, port := closingListener(t)
+	errRevoked := errors.New("revoked")
+	opens := map[string]func(db *Db) bool{
+		DatabaseTypeMysql: func(db *Db) bool {
+			return db.OpenMysql(MakeMysqlConfig(host, strconv.Itoa(port), "test", "user", "ignored"))
+		},
+		DatabaseTypePostgres: func(db *Db) bool {
+			return db.OpenPostgres(&postgres.Config{Host: host, Port: uint16(port), User: "user", Password: "ignored", Database: "test"})
+		},
+	}
+	for dbType, open := range opens {
+		t.Run(dbType, func(t *testing.T) {
+			var calls atomic.Int32
+			var db Db
+			db.SetErrorReporter(SilentReporter{})
+			db.SetCredentialProvider(CredentialFunc(func(ctx context.Context) (string, error) {
+				if calls.Add(1) > 2 {
+					return "", errRevoked
+				}
+				return fmt.Sprintf("password-%d", calls.Load()), nil
+			}))
+			if !open(&db) {
+				t.Fatal("open failed")
+			}
+			defer db.Close()
+			// 每次新建连接都重新获取密码, 服务器关闭了连接, 所以每次 Ping 都新建连接
+			for i := 1; i <= 2; i++ {
+				if err := db.db.Ping(); err == nil {
+					t.Fatal("Ping succeeded against a closing listener")
+				}
+				if n := calls.Load(); n != int32(i) {
+					t.Fatalf("provider called %d times after %d pings", n, i)
+				}
+			}
+			if err := db.db.Ping(); !errors.Is(err, errRevoked) {
+				t.Fatalf("Ping = %v, want the provider error", err)
+			}
+		})
+	}
+}
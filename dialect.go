@@ -0,0 +1,86 @@
+package judb
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Type 返回数据库类型, DatabaseTypeMysql, DatabaseTypeSqlite 或 DatabaseTypePostgres, 没有打开时是空串
+func (db *Db) Type() string {
+	return db.dbType
+}
+
+// Placeholder 返回第 n 个参数的占位符, n 从 1 开始. PostgreSQL 是 $n, 其它数据库是 ?
+func (db *Db) Placeholder(n int) string {
+	if db.dbType == DatabaseTypePostgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// Rebind 把语句中的 ? 占位符转换为当前数据库的格式, 字符串, 引号包裹的标识符和注释中的 ? 不会被替换.
+// 这样同一条语句可以用于所有的数据库类型. 注意 PostgreSQL 的 jsonb 运算符 ? 也会被替换, 这种语句不要使用 Rebind.
+func (db *Db) Rebind(sqlCase string) string {
+	if db.dbType != DatabaseTypePostgres || !strings.Contains(sqlCase, "?") {
+		return sqlCase
+	}
+	var builder strings.Builder
+	builder.Grow(len(sqlCase) + 8)
+	n := 0
+	for i := 0; i < len(sqlCase); {
+		c := sqlCase[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(sqlCase[i+1:], c)
+			if end < 0 {
+				builder.WriteString(sqlCase[i:])
+				return builder.String()
+			}
+			builder.WriteString(sqlCase[i : i+end+2])
+			i += end + 2
+		case c == '-' && strings.HasPrefix(sqlCase[i:], "--"):
+			end := strings.IndexByte(sqlCase[i:], '\n')
+			if end < 0 {
+				builder.WriteString(sqlCase[i:])
+				return builder.String()
+			}
+			builder.WriteString(sqlCase[i : i+end+1])
+			i += end + 1
+		case c == '/' && strings.HasPrefix(sqlCase[i:], "/*"):
+			end := strings.Index(sqlCase[i+2:], "*/")
+			if end < 0 {
+				builder.WriteString(sqlCase[i:])
+				return builder.String()
+			}
+			builder.WriteString(sqlCase[i : i+end+4])
+			i += end + 4
+		case c == '?':
+			n++
+			builder.WriteByte('$')
+			builder.WriteString(strconv.Itoa(n))
+			i++
+		default:
+			builder.WriteByte(c)
+			i++
+		}
+	}
+	return builder.String()
+}
+
+// QuoteIdent 用当前数据库的规则给标识符加引号, MySQL 使用反引号, 其它数据库使用双引号.
+// 带点的名称会分段处理, 例如 main.users
+func (db *Db) QuoteIdent(name string) string {
+	return quoteIdent(db.dbType, name)
+}
+
+func quoteIdent(dbType, name string) string {
+	quote := `"`
+	if dbType == DatabaseTypeMysql {
+		quote = "`"
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,230 @@
+package judb
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCSV       = "csv"
+	FormatJSONLines = "jsonl"
+)
+
+// DataFormat 导出和导入的数据格式. 值的转换规则:
+//
+//   - NULL: CSV 中是 NullText, JSON Lines 中是 null
+//   - 时间: 按 TimeLayout 格式化, 默认 time.RFC3339Nano
+//   - 二进制 (BLOB, BINARY, BYTEA 等): base64 编码的字符串
+//   - DECIMAL 和 NUMERIC: 数据库返回的文本, JSON Lines 中是数字, 不会损失精度
+//   - 整数, 浮点数和布尔值: JSON Lines 中是数字和布尔值, NaN 和 Inf 是字符串
+type DataFormat struct {
+	Kind       string // FormatCSV 或 FormatJSONLines
+	Delimiter  rune   // CSV 的分隔符, 默认是逗号
+	NoHeader   bool   // CSV 没有表头
+	NullText   string // CSV 中 NULL 的表示, 默认是空串, 导入时空串需要同时设置 ImportOptions.EmptyIsNull
+	TimeLayout string // 时间的格式, 默认是 time.RFC3339Nano
+}
+
+// CSVFormat 返回使用 delimiter 分隔, 带表头的 CSV 格式
+func CSVFormat(delimiter rune) DataFormat {
+	return DataFormat{Kind: FormatCSV, Delimiter: delimiter}
+}
+
+// JSONLinesFormat 返回 JSON Lines 格式, 每行是一个以列名为键的 JSON 对象
+func JSONLinesFormat() DataFormat {
+	return DataFormat{Kind: FormatJSONLines}
+}
+
+func (f DataFormat) timeLayout() string {
+	if f.TimeLayout == "" {
+		return time.RFC3339Nano
+	}
+	return f.TimeLayout
+}
+
+func (f DataFormat) delimiter() rune {
+	if f.Delimiter == 0 {
+		return ','
+	}
+	return f.Delimiter
+}
+
+// Export 执行查询并把结果逐行写入 w, 返回写入的行数. 数据不会全部读入内存, 可以导出任意大小的表.
+func (db *Db) Export(w io.Writer, format DataFormat, sqlCase string, args ...interface{}) (int64, SqlResult) {
+	count, err := db.export(w, format, sqlCase, args...)
+	if db.reportError(errSkip, err) {
+		return count, NewSqlResult(err)
+	}
+	return count, SqlResult{}
+}
+
+func (db *Db) export(w io.Writer, format DataFormat, sqlCase string, args ...interface{}) (int64, error) {
+	var count int64
+	var writeRow func(values []interface{}) error
+	var flush func() error
+	var columns []exportColumn
+
+	switch format.Kind {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Comma = format.delimiter()
+		record := make([]string, 0)
+		writeRow = func(values []interface{}) error {
+			record = record[:0]
+			for i, v := range values {
+				record = append(record, columns[i].text(v, format))
+			}
+			return cw.Write(record)
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case FormatJSONLines:
+		bw := bufio.NewWriter(w)
+		var line []byte
+		writeRow = func(values []interface{}) error {
+			line = append(line[:0], '{')
+			for i, v := range values {
+				if i > 0 {
+					line = append(line, ',')
+				}
+				line = append(line, columns[i].key...)
+				line = append(line, ':')
+				line = columns[i].appendJSON(line, v, format)
+			}
+			line = append(line, '}', '\n')
+			_, err := bw.Write(line)
+			return err
+		}
+		flush = bw.Flush
+	default:
+		return 0, fmt.Errorf("不支持的数据格式 %q", format.Kind)
+	}
+
+	err := db.query(sqlCase, func(rows *sql.Rows) error {
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			return err
+		}
+		header := make([]string, len(types))
+		for i, ct := range types {
+			columns = append(columns, newExportColumn(db.dbType, ct))
+			header[i] = ct.Name()
+		}
+		if format.Kind == FormatCSV && !format.NoHeader {
+			if err = writeRow(stringsToValues(header)); err != nil {
+				return err
+			}
+		}
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range dest {
+			dest[i] = &values[i]
+		}
+		for rows.Next() {
+			if err = rows.Scan(dest...); err != nil {
+				return err
+			}
+			if err = writeRow(values); err != nil {
+				return err
+			}
+			count++
+		}
+		return rows.Err()
+	}, args...)
+	if ferr := flush(); err == nil {
+		err = ferr
+	}
+	return count, err
+}
+
+func stringsToValues(list []string) []interface{} {
+	values := make([]interface{}, len(list))
+	for i, s := range list {
+		values[i] = s
+	}
+	return values
+}
+
+type exportColumn struct {
+	key     []byte // JSON 编码后的列名
+	binary  bool
+	numeric bool
+}
+
+func newExportColumn(dbType string, ct *sql.ColumnType) exportColumn {
+	key, _ := json.Marshal(ct.Name())
+	name := strings.ToUpper(ct.DatabaseTypeName())
+	return exportColumn{
+		key: key,
+		// SQLite 只有 BLOB 值才会返回 []byte, 没有声明类型的表达式列也按二进制处理
+		binary: strings.Contains(name, "BLOB") || strings.Contains(name, "BINARY") || name == "BYTEA" || dbType == DatabaseTypeSqlite && name == "",
+		// SQLite 返回声明的类型, 例如 DECIMAL(10,2)
+		numeric: strings.HasPrefix(name, "DECIMAL") || strings.HasPrefix(name, "NUMERIC"),
+	}
+}
+
+// text 返回值在 CSV 中的文本
+func (c exportColumn) text(v interface{}, format DataFormat) string {
+	switch x := v.(type) {
+	case nil:
+		return format.NullText
+	case string:
+		return x
+	case []byte:
+		if c.binary {
+			return base64.StdEncoding.EncodeToString(x)
+		}
+		return string(x)
+	case time.Time:
+		return x.Format(format.timeLayout())
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	return fmt.Sprint(v)
+}
+
+// appendJSON 把值的 JSON 编码追加到 buf
+func (c exportColumn) appendJSON(buf []byte, v interface{}, format DataFormat) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case int64:
+		return strconv.AppendInt(buf, x, 10)
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return strconv.AppendQuote(buf, strconv.FormatFloat(x, 'g', -1, 64))
+		}
+		return strconv.AppendFloat(buf, x, 'g', -1, 64)
+	case float32:
+		// 按 float32 的精度格式化, 否则 0.1 会输出为 0.10000000149011612
+		if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+			return strconv.AppendQuote(buf, strconv.FormatFloat(float64(x), 'g', -1, 32))
+		}
+		return strconv.AppendFloat(buf, float64(x), 'g', -1, 32)
+	case bool:
+		return strconv.AppendBool(buf, x)
+	}
+	s := c.text(v, format)
+	if c.numeric && json.Valid([]byte(s)) {
+		return append(buf, s...)
+	}
+	b, _ := json.Marshal(s)
+	return append(buf, b...)
+}
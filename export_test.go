@@ -0,0 +1,148 @@
+package judb
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const exportTableSQL = `CREATE TABLE items (
+	id INTEGER PRIMARY KEY,
+	name TEXT,
+	price DECIMAL(10,2),
+	big NUMERIC(30,10),
+	data BLOB,
+	ratio REAL,
+	created DATETIME,
+	flag BOOLEAN
+)`
+
+func openExportDb(t *testing.T, name string) *Db {
+	t.Helper()
+	db := &Db{}
+	if !db.OpenSqlite3(filepath.Join(t.TempDir(), name), "") {
+		t.Fatal("open sqlite failed")
+	}
+	t.Cleanup(db.Close)
+	if mr := db.Exec(exportTableSQL); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	return db
+}
+
+func exportItems(t *testing.T, db *Db, format DataFormat) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, mr := db.Export(&buf, format, "SELECT * FROM items ORDER BY id"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	return buf.String()
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := openExportDb(t, "src.db")
+	rows := [][]interface{}{
+		{1, "plain", "12.50", "12345678901234567890.1234567890", []byte{0, 1, 2, 0xfe, 0xff}, 0.25, "2024-05-06 07:08:09.123", true},
+		{2, "", "-0.01", "0", []byte{}, -1.5e-7, "2024-01-01 00:00:00", false},
+		{3, nil, nil, nil, nil, nil, nil, nil},
+		{4, "quote \" comma , newline \n tab \t", "99999999.99", "-1.5", []byte("text bytes"), 1e300, "1999-12-31 23:59:59", true},
+	}
+	for _, row := range rows {
+		if mr := src.Exec("INSERT INTO items VALUES (?, ?, ?, ?, ?, ?, ?, ?)", row...); mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+	}
+
+	formats := map[string]DataFormat{
+		"csv":   {Kind: FormatCSV, NullText: `\N`},
+		"jsonl": JSONLinesFormat(),
+	}
+	for name, format := range formats {
+		t.Run(name, func(t *testing.T) {
+			exported := exportItems(t, src, format)
+			dst := openExportDb(t, "dst.db")
+			result, mr := dst.Import("items", strings.NewReader(exported), format, ImportOptions{})
+			if mr.Fail() {
+				t.Fatal(mr.Error)
+			}
+			if result.Records != len(rows) || result.Inserted != int64(len(rows)) || len(result.Rejected) != 0 {
+				t.Fatalf("result = %+v", result)
+			}
+			if again := exportItems(t, dst, format); again != exported {
+				t.Fatalf("round trip changed the data:\n%s\nwant\n%s", again, exported)
+			}
+			var isNull bool
+			if err := dst.QueryRow("SELECT name IS NULL AND data IS NULL AND price IS NULL FROM items WHERE id = 3").Scan(&isNull); err != nil || !isNull {
+				t.Fatalf("row 3 is not NULL: %v", err)
+			}
+			var empty string
+			if err := dst.QueryRow("SELECT name FROM items WHERE id = 2").Scan(&empty); err != nil || empty != "" {
+				t.Fatalf("empty name = %q, %v", empty, err)
+			}
+			var data []byte
+			if err := dst.QueryRow("SELECT data FROM items WHERE id = 1").Scan(&data); err != nil || !bytes.Equal(data, rows[0][4].([]byte)) {
+				t.Fatalf("data = %v, %v", data, err)
+			}
+		})
+	}
+
+	lines := strings.Split(exportItems(t, src, JSONLinesFormat()), "\n")
+	for _, want := range []string{`"price":12.5`, `"data":"AAEC/v8="`, `"created":"2024-05-06T07:08:09.123Z"`, `"flag":true`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("line %s does not contain %s", lines[0], want)
+		}
+	}
+	if want := `{"id":3,"name":null,"price":null,"big":null,"data":null,"ratio":null,"created":null,"flag":null}`; lines[2] != want {
+		t.Errorf("NULL row = %s, want %s", lines[2], want)
+	}
+}
+
+func TestExportColumnNumeric(t *testing.T) {
+	db := openExportDb(t, "numeric.db")
+	rows, err := db.db.Query("SELECT name, price, big FROM items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := rows.ColumnTypes()
+	_ = rows.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SQLite 返回声明的类型 DECIMAL(10,2) 和 NUMERIC(30,10)
+	for i, want := range []bool{false, true, true} {
+		if c := newExportColumn(db.dbType, types[i]); c.numeric != want {
+			t.Errorf("column %s (%s) numeric = %t", types[i].Name(), types[i].DatabaseTypeName(), c.numeric)
+		}
+	}
+
+	// MySQL 和 PostgreSQL 返回 DECIMAL 的文本, 输出为数字不损失精度, 不是数字的文本仍然是字符串
+	c := exportColumn{numeric: true}
+	for v, want := range map[string]string{
+		"12345678901234567890.1234567890": "12345678901234567890.1234567890",
+		"-0.01":                           "-0.01",
+		"NaN":                             `"NaN"`,
+	} {
+		if got := string(c.appendJSON(nil, []byte(v), JSONLinesFormat())); got != want {
+			t.Errorf("appendJSON(%s) = %s, want %s", v, got, want)
+		}
+	}
+}
+
+func TestAppendJSONFloat32(t *testing.T) {
+	var c exportColumn
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{float32(0.1), "0.1"},
+		{float32(1) / 3, "0.33333334"},
+		{float64(float32(0.1)), "0.10000000149011612"},
+		{float32(-2.5e-8), "-2.5e-08"},
+	}
+	for _, tt := range tests {
+		if got := string(c.appendJSON(nil, tt.v, JSONLinesFormat())); got != tt.want {
+			t.Errorf("appendJSON(%T %v) = %s, want %s", tt.v, tt.v, got, tt.want)
+		}
+	}
+}
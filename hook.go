@@ -0,0 +1,108 @@
+package judb
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// QueryEvent 的 Op 取值
+const (
+	OpQuery    = "query"
+	OpQueryRow = "query_row"
+	OpExec     = "exec"
+	OpBegin    = "begin"
+	OpCommit   = "commit"
+	OpRollback = "rollback"
+)
+
+// QueryEvent 描述一次数据库调用, 在 Hook 的 Before 和 After 之间传递的是同一个对象
+type QueryEvent struct {
+	Op        string        // 调用类型, OpQuery, OpExec 等
+	SQL       string        // 执行的语句, 事务的 begin, commit, rollback 为空串
+	Operation string        // 语句的第一个关键字的大写形式, 例如 SELECT, INSERT, 事务操作是 BEGIN, COMMIT, ROLLBACK
+	Args      []interface{} // 绑定的参数
+	Dialect   string        // 数据库类型, DatabaseTypeMysql, DatabaseTypeSqlite 或 DatabaseTypePostgres
+	InTx      bool          // 是否在事务中执行
+
+	Start        time.Time     // 开始时间
+	Duration     time.Duration // 耗时, 只在 After 中有效. Db 的 Query 的耗时包含回调函数读取数据的时间, QueryRow 和 Tx.Query 的不包含
+	RowsAffected int64         // 影响的行数, 只在 Exec 成功后有效, 其它情况是 -1
+	Result       *SqlResult    // 执行结果, 只在 After 中有效
+}
+
+// Hook 数据库调用的中间件, 可以用于跟踪, 统计, 审计等.
+//
+// Before 在语句执行前调用, 返回的 context 会传给驱动和后面的 Hook. Before 可以修改 event 的 SQL 和 Args,
+// 执行的是修改后的语句, 例如增加租户过滤条件.
+//
+// After 在语句执行后调用, 多个 Hook 的 After 按照注册的相反顺序调用.
+type Hook interface {
+	Before(ctx context.Context, event *QueryEvent) context.Context
+	After(ctx context.Context, event *QueryEvent)
+}
+
+// AddHook 注册 Hook, 它同时作用于 Db 的 Query, QueryRow, Exec 和 BeginHooked 返回的事务.
+// 这个函数不是并发安全的, 需要在使用 Db 之前调用.
+func (db *Db) AddHook(hooks ...Hook) {
+	db.hooks = append(db.hooks, hooks...)
+}
+
+func (db *Db) beforeHooks(ctx context.Context, op, sqlCase string, args []interface{}, inTx bool) (context.Context, *QueryEvent) {
+	event := &QueryEvent{
+		Op:           op,
+		SQL:          sqlCase,
+		Operation:    statementOperation(sqlCase),
+		Args:         args,
+		Dialect:      db.dbType,
+		InTx:         inTx,
+		Start:        time.Now(),
+		RowsAffected: -1,
+	}
+	switch op {
+	case OpBegin, OpCommit, OpRollback:
+		event.Operation = strings.ToUpper(op)
+	}
+	for _, h := range db.hooks {
+		ctx = h.Before(ctx, event)
+	}
+	return ctx, event
+}
+
+// statementOperation 返回语句的第一个关键字, 跳过开头的空白, 注释和括号. 空语句是事务操作, 调用者需要自己设置
+func statementOperation(sqlCase string) string {
+	for i := 0; i < len(sqlCase); {
+		c := sqlCase[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(':
+			i++
+		case strings.HasPrefix(sqlCase[i:], "--"):
+			end := strings.IndexByte(sqlCase[i:], '\n')
+			if end < 0 {
+				return ""
+			}
+			i += end + 1
+		case strings.HasPrefix(sqlCase[i:], "/*"):
+			end := strings.Index(sqlCase[i+2:], "*/")
+			if end < 0 {
+				return ""
+			}
+			i += end + 4
+		default:
+			end := i
+			for end < len(sqlCase) && (sqlCase[end] >= 'a' && sqlCase[end] <= 'z' || sqlCase[end] >= 'A' && sqlCase[end] <= 'Z') {
+				end++
+			}
+			return strings.ToUpper(sqlCase[i:end])
+		}
+	}
+	return ""
+}
+
+func (db *Db) afterHooks(ctx context.Context, event *QueryEvent, mr *SqlResult) {
+	event.Duration = time.Since(event.Start)
+	event.Result = mr
+	for i := len(db.hooks) - 1; i >= 0; i-- {
+		db.hooks[i].After(ctx, event)
+	}
+}
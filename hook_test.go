@@ -0,0 +1,181 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// traceHook 把 Before 和 After 的调用依次记录到 log
+type traceHook struct {
+	name string
+	log  *[]string
+}
+
+type traceKey struct{}
+
+func (h traceHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	*h.log = append(*h.log, fmt.Sprintf("%s before %s %s", h.name, event.Op, event.Operation))
+	return context.WithValue(ctx, traceKey{}, h.name)
+}
+
+func (h traceHook) After(ctx context.Context, event *QueryEvent) {
+	entry := fmt.Sprintf("%s after %s %s", h.name, event.Op, event.Operation)
+	if event.Result.Fail() {
+		entry += " error"
+	}
+	if ctx.Value(traceKey{}) == nil {
+		entry += " lost context"
+	}
+	*h.log = append(*h.log, entry)
+}
+
+// tenantHook 在 Before 中修改语句和参数
+type tenantHook struct{}
+
+func (tenantHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	if event.Operation == "SELECT" && strings.Contains(event.SQL, "FROM items") {
+		event.SQL += " WHERE tenant = ?"
+		event.Args = append(event.Args, "a")
+	}
+	return ctx
+}
+
+func (tenantHook) After(context.Context, *QueryEvent) {}
+
+func openHookDb(t *testing.T, hooks ...Hook) *Db {
+	t.Helper()
+	db := &Db{}
+	if !db.OpenSqlite3(filepath.Join(t.TempDir(), "hook.db"), "") {
+		t.Fatal("open sqlite failed")
+	}
+	t.Cleanup(db.Close)
+	db.SetErrorReporter(SilentReporter{})
+	for _, s := range []string{
+		"CREATE TABLE items (id INTEGER PRIMARY KEY, tenant TEXT)",
+		"INSERT INTO items (tenant) VALUES ('a'), ('b'), ('a')",
+	} {
+		if mr := db.Exec(s); mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+	}
+	db.AddHook(hooks...)
+	return db
+}
+
+func TestHookOrder(t *testing.T) {
+	var log []string
+	db := openHookDb(t, traceHook{"h1", &log}, traceHook{"h2", &log})
+
+	db.Exec("UPDATE items SET tenant = 'c' WHERE id = 2")
+	db.Query("SELECT id FROM items", func(rows *sql.Rows) {
+		log = append(log, "read rows")
+	})
+	db.Exec("INSERT INTO missing VALUES (1)")
+	want := []string{
+		"h1 before exec UPDATE", "h2 before exec UPDATE", "h2 after exec UPDATE", "h1 after exec UPDATE",
+		"h1 before query SELECT", "h2 before query SELECT", "read rows", "h2 after query SELECT", "h1 after query SELECT",
+		"h1 before exec INSERT", "h2 before exec INSERT", "h2 after exec INSERT error", "h1 after exec INSERT error",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("log =\n%s\nwant\n%s", strings.Join(log, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestHookResult(t *testing.T) {
+	var events []QueryEvent
+	db := openHookDb(t, &recordHook{events: &events})
+
+	db.Exec("UPDATE items SET tenant = 'a'")
+	mr := db.Exec("INSERT INTO missing VALUES (1)")
+	if len(events) != 2 {
+		t.Fatalf("events = %d", len(events))
+	}
+	if e := events[0]; e.RowsAffected != 3 || e.Result.Fail() || e.Dialect != DatabaseTypeSqlite || e.Duration <= 0 {
+		t.Fatalf("update event = %+v", e)
+	}
+	e := events[1]
+	if !e.Result.Fail() || e.Result.Error != mr.Error || !strings.Contains(e.Result.Error, "no such table") || e.RowsAffected != -1 {
+		t.Fatalf("failed insert event = %+v, result %+v", e, e.Result)
+	}
+}
+
+// recordHook 在 After 中保存事件的副本
+type recordHook struct {
+	events *[]QueryEvent
+}
+
+func (h *recordHook) Before(ctx context.Context, _ *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *recordHook) After(_ context.Context, event *QueryEvent) {
+	*h.events = append(*h.events, *event)
+}
+
+func TestHookRewrite(t *testing.T) {
+	db := openHookDb(t, tenantHook{})
+	var ids []int
+	mr := db.Query("SELECT id FROM items", func(rows *sql.Rows) {
+		for rows.Next() {
+			var id int
+			_ = rows.Scan(&id)
+			ids = append(ids, id)
+		}
+	})
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Fatalf("ids = %v, want [1 3]", ids)
+	}
+}
+
+func TestHookTx(t *testing.T) {
+	var log []string
+	db := openHookDb(t, traceHook{"h", &log})
+
+	tx, mr := db.BeginHooked()
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.Exec("DELETE FROM items WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := tx.Query("SELECT id FROM items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tx.Query 的 After 在读取数据之前调用
+	log = append(log, "read rows")
+	for rows.Next() {
+	}
+	_ = rows.Close()
+	if _, err = tx.Exec("DELETE FROM missing"); err == nil {
+		t.Fatal("delete from a missing table succeeded")
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// 已经结束的事务 Rollback 不调用 Hook
+	if err = tx.Rollback(); err != sql.ErrTxDone {
+		t.Fatalf("Rollback = %v", err)
+	}
+	want := []string{
+		"h before begin BEGIN", "h after begin BEGIN",
+		"h before exec DELETE", "h after exec DELETE",
+		"h before query SELECT", "h after query SELECT", "read rows",
+		"h before exec DELETE", "h after exec DELETE error",
+		"h before commit COMMIT", "h after commit COMMIT",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("log =\n%s\nwant\n%s", strings.Join(log, "\n"), strings.Join(want, "\n"))
+	}
+}
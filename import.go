@@ -0,0 +1,459 @@
+package judb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultImportBatchSize ImportOptions.BatchSize 没有设置时每个事务插入的行数
+const DefaultImportBatchSize = 500
+
+// ImportOptions 导入的设置
+type ImportOptions struct {
+	// Columns 字段名到列名的映射, 覆盖按名称的匹配, 列名为 "-" 时忽略这个字段.
+	// 没有映射的字段按名称匹配列, 不区分大小写, 没有对应列的字段会被忽略.
+	Columns   map[string]string
+	BatchSize int // 每个事务插入的行数, 默认 DefaultImportBatchSize
+	// MaxRejects 拒绝的行超过这个数量时停止导入, 0 表示不限制. 停止前已经提交的行不会回滚.
+	MaxRejects int
+	// EmptyIsNull CSV 中的空字段作为 NULL. 默认空字段是空串, 只有等于 NullText 的字段是 NULL,
+	// 导入使用默认 NullText 导出的 CSV 时需要设置
+	EmptyIsNull bool
+}
+
+// ImportReject 一个被拒绝的行, Line 是在输入中的行号, 从 1 开始
+type ImportReject struct {
+	Line   int
+	Reason string
+}
+
+// ImportResult 导入的结果
+type ImportResult struct {
+	Records  int   // 读取的记录数, 不包含 CSV 的表头
+	Inserted int64 // 插入的行数
+	Rejected []ImportReject
+}
+
+// Import 把 CSV 或 JSON Lines 数据导入到表 table, 字段值按表结构中的列类型转换, 转换规则和 Export 相反,
+// 所以 Export 的输出可以直接导入, NullText 为空串时需要设置 EmptyIsNull. CSV 设置了 NoHeader 时, 字段按顺序对应表的列.
+//
+// 数据按 BatchSize 分批在事务中插入, 转换失败或插入失败的行记录在 ImportResult.Rejected 中, 不会中止导入.
+// 一批中有插入失败的行时, 这一批会回滚, 然后逐行使用 SAVEPOINT 重新插入, 只拒绝失败的行.
+func (db *Db) Import(table string, r io.Reader, format DataFormat, options ImportOptions) (*ImportResult, SqlResult) {
+	result, err := db.importData(table, r, format, options)
+	if db.reportError(errSkip, err) {
+		return result, NewSqlResult(err)
+	}
+	return result, SqlResult{}
+}
+
+type importRow struct {
+	line    int
+	columns []string
+	values  []interface{}
+}
+
+type importer struct {
+	db      *Db
+	table   string
+	format  DataFormat
+	options ImportOptions
+	columns map[string]*Column // 小写的列名
+	order   []Column
+	result  *ImportResult
+	batch   []importRow
+	inserts map[string]string // 列的组合对应的 INSERT 语句
+}
+
+func (db *Db) importData(table string, r io.Reader, format DataFormat, options ImportOptions) (*ImportResult, error) {
+	columns, err := db.columns(table)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("表 %s 不存在", table)
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultImportBatchSize
+	}
+	im := &importer{db: db, table: table, format: format, options: options, order: columns,
+		columns: map[string]*Column{}, result: &ImportResult{}, inserts: map[string]string{}}
+	for i := range columns {
+		im.columns[strings.ToLower(columns[i].Name)] = &columns[i]
+	}
+	for field, column := range options.Columns {
+		if column != "-" && im.columns[strings.ToLower(column)] == nil {
+			return nil, fmt.Errorf("字段 %s 映射的列 %s 不在表 %s 中", field, column, table)
+		}
+	}
+
+	switch format.Kind {
+	case FormatCSV:
+		err = im.readCSV(r)
+	case FormatJSONLines:
+		err = im.readJSONLines(r)
+	default:
+		err = fmt.Errorf("不支持的数据格式 %q", format.Kind)
+	}
+	if err == nil {
+		err = im.flush()
+	}
+	return im.result, err
+}
+
+// column 返回字段对应的列, 忽略的字段返回 nil
+func (im *importer) column(field string) *Column {
+	if name, ok := im.options.Columns[field]; ok {
+		if name == "-" {
+			return nil
+		}
+		field = name
+	}
+	return im.columns[strings.ToLower(field)]
+}
+
+func (im *importer) readCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.Comma = im.format.delimiter()
+	cr.ReuseRecord = true
+	var fields []*Column
+	if im.format.NoHeader {
+		for i := range im.order {
+			fields = append(fields, im.column(im.order[i].Name))
+		}
+	} else {
+		header, err := cr.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		for _, name := range header {
+			fields = append(fields, im.column(strings.TrimSpace(name)))
+		}
+	}
+	cr.FieldsPerRecord = len(fields)
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			im.result.Records++
+			if err = im.reject(parseErr.StartLine, parseErr.Err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		im.result.Records++
+		row := importRow{line: line}
+		var reason string
+		for i, text := range record {
+			col := fields[i]
+			if col == nil {
+				continue
+			}
+			var value interface{}
+			if !im.isNull(text) {
+				if value, err = im.coerce(col, text); err != nil {
+					reason = fmt.Sprintf("列 %s: %s", col.Name, err.Error())
+					break
+				}
+			}
+			row.columns = append(row.columns, col.Name)
+			row.values = append(row.values, value)
+		}
+		if err = im.add(row, reason); err != nil {
+			return err
+		}
+	}
+}
+
+func (im *importer) readJSONLines(r io.Reader) error {
+	br := bufio.NewReader(r)
+	line := 0
+	for {
+		data, err := br.ReadBytes('\n')
+		if len(data) == 0 && errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		line++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		im.result.Records++
+		row, reason := im.parseJSONLine(line, data)
+		if err = im.add(row, reason); err != nil {
+			return err
+		}
+	}
+}
+
+func (im *importer) parseJSONLine(line int, data []byte) (importRow, string) {
+	row := importRow{line: line}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var object map[string]interface{}
+	if err := dec.Decode(&object); err != nil {
+		return row, err.Error()
+	}
+	if object == nil {
+		return row, "不是 JSON 对象"
+	}
+	// 按表中列的顺序排列, 相同字段组合的行使用相同的语句
+	values := map[string]interface{}{}
+	for field, v := range object {
+		if col := im.column(field); col != nil {
+			values[col.Name] = v
+		}
+	}
+	for _, col := range im.order {
+		v, ok := values[col.Name]
+		if !ok {
+			continue
+		}
+		var value interface{}
+		var err error
+		switch x := v.(type) {
+		case nil:
+		case json.Number:
+			value, err = im.coerce(&col, x.String())
+		case string:
+			value, err = im.coerce(&col, x)
+		case bool:
+			value, err = im.coerce(&col, strconv.FormatBool(x))
+		default:
+			// 对象和数组作为 JSON 文本保存
+			b, _ := json.Marshal(x)
+			value, err = im.coerce(&col, string(b))
+		}
+		if err != nil {
+			return row, fmt.Sprintf("列 %s: %s", col.Name, err.Error())
+		}
+		row.columns = append(row.columns, col.Name)
+		row.values = append(row.values, value)
+	}
+	return row, ""
+}
+
+// isNull CSV 字段是否表示 NULL, 空字段只有设置了 EmptyIsNull 才是 NULL
+func (im *importer) isNull(text string) bool {
+	if text == "" {
+		return im.options.EmptyIsNull
+	}
+	return text == im.format.NullText
+}
+
+// coerce 按列的类型转换字段的文本
+func (im *importer) coerce(col *Column, text string) (interface{}, error) {
+	typ := strings.ToLower(col.Type)
+	switch {
+	case isIntegerType(typ):
+		if v, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64); err == nil {
+			return v, nil
+		}
+		// MySQL 的 tinyint(1) 通常用于布尔值
+		if b, err := strconv.ParseBool(strings.TrimSpace(text)); err == nil {
+			if b {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+		return nil, fmt.Errorf("%q 不是整数", text)
+	case strings.Contains(typ, "bool"):
+		b, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("%q 不是布尔值", text)
+		}
+		return b, nil
+	case strings.Contains(typ, "decimal") || strings.Contains(typ, "numeric"):
+		text = strings.TrimSpace(text)
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return nil, fmt.Errorf("%q 不是数字", text)
+		}
+		return text, nil
+	case strings.Contains(typ, "real") || strings.Contains(typ, "float") || strings.Contains(typ, "double"):
+		v, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q 不是数字", text)
+		}
+		return v, nil
+	case strings.Contains(typ, "timestamp") || strings.Contains(typ, "datetime") || typ == "date":
+		return im.parseTime(strings.TrimSpace(text))
+	case strings.Contains(typ, "blob") || strings.Contains(typ, "binary") || typ == "bytea":
+		b, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("不是 base64 编码的二进制数据")
+		}
+		return b, nil
+	}
+	return text, nil
+}
+
+// integerTypes 整数类型的名称, 包括 PostgreSQL 的别名
+var integerTypes = map[string]bool{
+	"int": true, "integer": true, "bigint": true, "smallint": true, "tinyint": true, "mediumint": true,
+	"int2": true, "int4": true, "int8": true, "serial": true, "bigserial": true, "smallserial": true,
+}
+
+// isIntegerType 类型中是否有整数类型的名称, 按完整的词匹配, 例如 "bigint(20) unsigned" 是整数,
+// "point" 和 "interval" 不是
+func isIntegerType(typ string) bool {
+	for _, word := range strings.FieldsFunc(strings.ToLower(typ), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}) {
+		if integerTypes[word] {
+			return true
+		}
+	}
+	return false
+}
+
+var importTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+func (im *importer) parseTime(text string) (interface{}, error) {
+	if im.format.TimeLayout != "" {
+		if t, err := time.Parse(im.format.TimeLayout, text); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%q 不是时间", text)
+}
+
+func (im *importer) reject(line int, reason string) error {
+	im.result.Rejected = append(im.result.Rejected, ImportReject{Line: line, Reason: reason})
+	if im.options.MaxRejects > 0 && len(im.result.Rejected) > im.options.MaxRejects {
+		return fmt.Errorf("拒绝的行超过 %d, 停止导入", im.options.MaxRejects)
+	}
+	return nil
+}
+
+func (im *importer) add(row importRow, reason string) error {
+	if reason == "" && len(row.columns) == 0 {
+		reason = "没有对应表中列的字段"
+	}
+	if reason != "" {
+		return im.reject(row.line, reason)
+	}
+	im.batch = append(im.batch, row)
+	if len(im.batch) >= im.options.BatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+func (im *importer) insertSQL(columns []string) string {
+	key := strings.Join(columns, "\x00")
+	if sqlCase, ok := im.inserts[key]; ok {
+		return sqlCase
+	}
+	quoted := make([]string, len(columns))
+	holders := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = im.db.QuoteIdent(col)
+		holders[i] = im.db.Placeholder(i + 1)
+	}
+	sqlCase := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", im.db.QuoteIdent(im.table), strings.Join(quoted, ", "), strings.Join(holders, ", "))
+	im.inserts[key] = sqlCase
+	return sqlCase
+}
+
+// flush 在一个事务中插入当前批次, 失败时回滚并逐行重试
+func (im *importer) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	batch := im.batch
+	im.batch = im.batch[:0]
+
+	tx, err := im.db.begin()
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, row := range batch {
+		if _, err = tx.Exec(im.insertSQL(row.columns), row.values...); err != nil {
+			failed = true
+			break
+		}
+	}
+	if !failed {
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		im.result.Inserted += int64(len(batch))
+		return nil
+	}
+	_ = tx.Rollback()
+	return im.flushRows(batch)
+}
+
+func (im *importer) flushRows(batch []importRow) error {
+	tx, err := im.db.begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var inserted int64
+	for _, row := range batch {
+		if _, err = tx.Exec("SAVEPOINT judb_import"); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(im.insertSQL(row.columns), row.values...); err != nil {
+			if _, e := tx.Exec("ROLLBACK TO SAVEPOINT judb_import"); e != nil {
+				return e
+			}
+			if e := im.reject(row.line, NewSqlResult(err).Error); e != nil {
+				// 已经成功的行仍然提交
+				if ce := tx.Commit(); ce != nil {
+					return ce
+				}
+				im.result.Inserted += inserted
+				return e
+			}
+			continue
+		}
+		if _, err = tx.Exec("RELEASE SAVEPOINT judb_import"); err != nil {
+			return err
+		}
+		inserted++
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	im.result.Inserted += inserted
+	return nil
+}
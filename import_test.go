@@ -0,0 +1,190 @@
+package judb
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openImportDb(t *testing.T) *Db {
+	t.Helper()
+	db := &Db{}
+	if !db.OpenSqlite3(filepath.Join(t.TempDir(), "import.db"), "") {
+		t.Fatal("open sqlite failed")
+	}
+	t.Cleanup(db.Close)
+	db.SetErrorReporter(SilentReporter{})
+	if mr := db.Exec(`CREATE TABLE people (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		age INTEGER,
+		score DECIMAL(5,2),
+		active BOOLEAN,
+		born DATE,
+		photo BLOB,
+		code TEXT UNIQUE
+	)`); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	return db
+}
+
+type importedPerson struct {
+	Name   string
+	Age    sql.NullInt64
+	Score  sql.NullString
+	Active sql.NullBool
+	Born   sql.NullTime
+	Photo  []byte
+}
+
+func importedPeople(t *testing.T, db *Db) []importedPerson {
+	t.Helper()
+	var people []importedPerson
+	err := db.collect("SELECT name, age, score, active, born, photo FROM people ORDER BY id", nil, func(rows *sql.Rows) error {
+		var p importedPerson
+		err := rows.Scan(&p.Name, &p.Age, &p.Score, &p.Active, &p.Born, &p.Photo)
+		people = append(people, p)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return people
+}
+
+func rejectLines(result *ImportResult) []int {
+	var lines []int
+	for _, r := range result.Rejected {
+		lines = append(lines, r.Line)
+	}
+	return lines
+}
+
+func TestImportCSV(t *testing.T) {
+	db := openImportDb(t)
+	// 第 6 行的字段跨两行, 之后的行号仍然是输入中的行号
+	data := "Full Name,AGE,score,active,born,photo,comment\n" +
+		"alice,30,12.50,true,2024-01-02,AAEC/w==,ignored\n" +
+		"bob,abc,1,false,2024-01-02,,\n" +
+		"carol,,,,,,\n" +
+		"dave,40,1,yes,2024-01-02,,\n" +
+		"\"eve\nsecond line\",41,2.5,0,2024-02-03T04:05:06Z,,\n" +
+		"frank,42,1,1,not a date,,\n" +
+		"grace,43,1,1,2024-01-02,not base64!,\n"
+	options := ImportOptions{Columns: map[string]string{"Full Name": "name", "comment": "-"}, EmptyIsNull: true}
+	result, mr := db.Import("people", strings.NewReader(data), CSVFormat(0), options)
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if result.Records != 7 || result.Inserted != 3 {
+		t.Fatalf("result = %+v", result)
+	}
+	if lines := rejectLines(result); !reflect.DeepEqual(lines, []int{3, 5, 8, 9}) {
+		t.Fatalf("rejected lines = %v, want [3 5 8 9]: %+v", lines, result.Rejected)
+	}
+	for i, column := range []string{"age", "active", "born", "photo"} {
+		if reason := result.Rejected[i].Reason; !strings.Contains(reason, "列 "+column) {
+			t.Errorf("reason %q does not name column %s", reason, column)
+		}
+	}
+
+	people := importedPeople(t, db)
+	if len(people) != 3 {
+		t.Fatalf("people = %+v", people)
+	}
+	alice := people[0]
+	if alice.Name != "alice" || alice.Age.Int64 != 30 || alice.Score.String != "12.5" || !alice.Active.Bool ||
+		!alice.Born.Time.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) || !bytes.Equal(alice.Photo, []byte{0, 1, 2, 0xff}) {
+		t.Fatalf("alice = %+v", alice)
+	}
+	// EmptyIsNull 时空字段是 NULL
+	if carol := people[1]; carol.Age.Valid || carol.Score.Valid || carol.Active.Valid || carol.Born.Valid || carol.Photo != nil {
+		t.Fatalf("carol = %+v", carol)
+	}
+	if eve := people[2]; eve.Name != "eve\nsecond line" || eve.Active.Bool || !eve.Born.Time.Equal(time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)) {
+		t.Fatalf("eve = %+v", eve)
+	}
+}
+
+func TestImportEmptyIsNull(t *testing.T) {
+	db := openImportDb(t)
+	data := "name,age,code\n,,\n"
+	// 默认空字段是空串, 空串不是整数
+	result, mr := db.Import("people", strings.NewReader(data), CSVFormat(0), ImportOptions{})
+	if mr.Fail() || result.Inserted != 0 || len(result.Rejected) != 1 || !strings.Contains(result.Rejected[0].Reason, "列 age") {
+		t.Fatalf("result = %+v, %s", result, mr.Error)
+	}
+	result, mr = db.Import("people", strings.NewReader("name,code\n,\n"), CSVFormat(0), ImportOptions{})
+	if mr.Fail() || result.Inserted != 1 {
+		t.Fatalf("result = %+v, %s", result, mr.Error)
+	}
+	var code sql.NullString
+	if err := db.QueryRow("SELECT code FROM people").Scan(&code); err != nil || !code.Valid || code.String != "" {
+		t.Fatalf("code = %+v, %v", code, err)
+	}
+	// 设置 EmptyIsNull 后空的 name 是 NULL, 违反 NOT NULL
+	result, mr = db.Import("people", strings.NewReader("name,code\n,x\n"), CSVFormat(0), ImportOptions{EmptyIsNull: true})
+	if mr.Fail() || result.Inserted != 0 || len(result.Rejected) != 1 || !strings.Contains(result.Rejected[0].Reason, "NOT NULL") {
+		t.Fatalf("result = %+v, %s", result, mr.Error)
+	}
+}
+
+func TestImportBatchRetry(t *testing.T) {
+	db := openImportDb(t)
+	// 第 3 行的 code 重复, 第 5 行没有 name, 转换都能成功, 插入时失败. 空行也计入行号
+	data := `{"name":"a","code":"x"}
+{"name":"b","code":"y"}
+{"name":"c","code":"x"}
+
+{"code":"z","age":5}
+{"name":"d","code":"z","score":1.25}
+{"name":"e"}
+`
+	result, mr := db.Import("people", strings.NewReader(data), JSONLinesFormat(), ImportOptions{BatchSize: 3})
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if result.Records != 6 || result.Inserted != 4 {
+		t.Fatalf("result = %+v", result)
+	}
+	if lines := rejectLines(result); !reflect.DeepEqual(lines, []int{3, 5}) {
+		t.Fatalf("rejected lines = %v, want [3 5]: %+v", lines, result.Rejected)
+	}
+	if !strings.Contains(result.Rejected[0].Reason, "UNIQUE") || !strings.Contains(result.Rejected[1].Reason, "NOT NULL") {
+		t.Fatalf("rejected = %+v", result.Rejected)
+	}
+	var names []string
+	for _, p := range importedPeople(t, db) {
+		names = append(names, p.Name)
+	}
+	// 失败的批次只拒绝失败的行, 同一批中的其它行都插入了
+	if !reflect.DeepEqual(names, []string{"a", "b", "d", "e"}) {
+		t.Fatalf("names = %v", names)
+	}
+}
+
+func TestImportMaxRejects(t *testing.T) {
+	db := openImportDb(t)
+	data := "name,age\na,1\nb,x\nc,3\nd,x\ne,x\nf,6\n"
+	result, mr := db.Import("people", strings.NewReader(data), CSVFormat(0), ImportOptions{BatchSize: 1, MaxRejects: 1})
+	if !mr.Fail() {
+		t.Fatal("import did not stop")
+	}
+	// 第二个拒绝的行超过了 MaxRejects, 停止前已经提交的行保留
+	if lines := rejectLines(result); result.Inserted != 2 || !reflect.DeepEqual(lines, []int{3, 5}) {
+		t.Fatalf("result = %+v", result)
+	}
+	if people := importedPeople(t, db); len(people) != 2 || people[1].Name != "c" {
+		t.Fatalf("people = %+v", people)
+	}
+
+	// 映射到不存在的列在读取数据之前报错
+	if _, mr = db.Import("people", strings.NewReader(data), CSVFormat(0), ImportOptions{Columns: map[string]string{"name": "missing"}}); !mr.Fail() {
+		t.Fatal("mapping to a missing column accepted")
+	}
+}
@@ -0,0 +1,138 @@
+// Package judbotel 为 judb 提供 OpenTelemetry 跟踪, 每次数据库调用生成一个 client span.
+//
+//	var db judb.Db
+//	db.OpenMysql(cfg)
+//	db.AddHook(judbotel.NewHook(judbotel.Options{DbName: "orders", ServerAddress: "10.0.0.8", ServerPort: 3306}))
+package judbotel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jsuserapp/judb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/jsuserapp/judb/judbotel"
+
+// Options 跟踪的参数, 所有字段都是可选的
+type Options struct {
+	// TracerProvider 为 nil 时使用 otel.GetTracerProvider(), 测试时可以传入使用内存 exporter 的 provider
+	TracerProvider trace.TracerProvider
+	DbName         string // db.name 属性
+	ServerAddress  string // server.address 属性
+	ServerPort     int    // server.port 属性, 0 表示不设置
+	// SanitizeStatement 把 db.statement 中的字符串和数字常量替换为 ?, 避免敏感数据进入跟踪系统
+	SanitizeStatement bool
+	// OmitStatement 不记录 db.statement
+	OmitStatement bool
+}
+
+type spanKey struct{}
+type txSpanKey struct{}
+
+type hook struct {
+	tracer trace.Tracer
+	opts   Options
+}
+
+// NewHook 创建跟踪用的 judb.Hook. 事务生成一个 transaction span, 事务中的语句和 commit, rollback 是它的子 span,
+// transaction span 在 commit 或 rollback 后结束.
+func NewHook(opts Options) judb.Hook {
+	tp := opts.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &hook{tracer: tp.Tracer(instrumentationName), opts: opts}
+}
+
+func (h *hook) Before(ctx context.Context, event *judb.QueryEvent) context.Context {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", dbSystem(event.Dialect)),
+		attribute.String("db.operation", event.Operation),
+	}
+	if h.opts.DbName != "" {
+		attrs = append(attrs, attribute.String("db.name", h.opts.DbName))
+	}
+	if h.opts.ServerAddress != "" {
+		attrs = append(attrs, attribute.String("server.address", h.opts.ServerAddress))
+	}
+	if h.opts.ServerPort != 0 {
+		attrs = append(attrs, attribute.Int("server.port", h.opts.ServerPort))
+	}
+	if event.SQL != "" && !h.opts.OmitStatement {
+		statement := event.SQL
+		if h.opts.SanitizeStatement {
+			statement = Sanitize(event.Dialect, statement)
+		}
+		attrs = append(attrs, attribute.String("db.statement", statement))
+	}
+
+	name := event.Operation
+	if event.Op == judb.OpBegin {
+		name = "transaction"
+	}
+	if name == "" {
+		name = event.Op
+	}
+	if h.opts.DbName != "" {
+		name += " " + h.opts.DbName
+	}
+	ctx, span := h.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	if event.Op == judb.OpBegin {
+		ctx = context.WithValue(ctx, txSpanKey{}, span)
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func (h *hook) After(ctx context.Context, event *judb.QueryEvent) {
+	span, ok := ctx.Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	failed := event.Result != nil && event.Result.Fail()
+	if failed {
+		if event.Result.Code != "" {
+			span.SetAttributes(attribute.String("db.response.status_code", event.Result.Code))
+		}
+		span.RecordError(fmt.Errorf("%s", event.Result.Error))
+		span.SetStatus(codes.Error, event.Result.Error)
+	}
+	if event.RowsAffected >= 0 {
+		span.SetAttributes(attribute.Int64("db.rows_affected", event.RowsAffected))
+	}
+	switch event.Op {
+	case judb.OpBegin:
+		// 开始事务成功时 transaction span 要等到 commit 或 rollback 才结束
+		if failed {
+			span.End()
+		}
+		return
+	case judb.OpCommit, judb.OpRollback:
+		span.End()
+		if txSpan, ok := ctx.Value(txSpanKey{}).(trace.Span); ok {
+			if failed {
+				txSpan.SetStatus(codes.Error, event.Result.Error)
+			}
+			txSpan.End()
+		}
+		return
+	}
+	span.End()
+}
+
+// dbSystem 返回 db.system 属性规定的数据库名称
+func dbSystem(dialect string) string {
+	switch dialect {
+	case judb.DatabaseTypePostgres:
+		return "postgresql"
+	case judb.DatabaseTypeMysql:
+		return "mysql"
+	case judb.DatabaseTypeSqlite:
+		return "sqlite"
+	}
+	return "other_sql"
+}
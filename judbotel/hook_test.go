@@ -0,0 +1,81 @@
+package judbotel
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsuserapp/judb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHook(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	var db judb.Db
+	if !db.OpenSqlite3(filepath.Join(t.TempDir(), "otel.db"), "") {
+		t.Fatal("open database failed")
+	}
+	defer db.Close()
+	db.AddHook(NewHook(Options{TracerProvider: tp, DbName: "app", SanitizeStatement: true}))
+
+	if mr := db.Exec("CREATE TABLE users (name TEXT, age INTEGER)"); mr.Error != "" {
+		t.Fatal(mr.Error)
+	}
+	if mr := db.Exec("INSERT INTO users (name, age) VALUES ('secret', 42)"); mr.Error != "" {
+		t.Fatal(mr.Error)
+	}
+	db.Query("SELECT name FROM missing WHERE name = 'secret'", func(rows *sql.Rows) {})
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+
+	insert := spans[1]
+	if insert.Name != "INSERT app" {
+		t.Errorf("span name = %q, want %q", insert.Name, "INSERT app")
+	}
+	if insert.SpanKind != trace.SpanKindClient {
+		t.Errorf("span kind = %v, want client", insert.SpanKind)
+	}
+	want := map[attribute.Key]attribute.Value{
+		"db.system":        attribute.StringValue("sqlite"),
+		"db.operation":     attribute.StringValue("INSERT"),
+		"db.name":          attribute.StringValue("app"),
+		"db.statement":     attribute.StringValue("INSERT INTO users (name, age) VALUES (?, ?)"),
+		"db.rows_affected": attribute.Int64Value(1),
+	}
+	got := map[attribute.Key]attribute.Value{}
+	for _, kv := range insert.Attributes {
+		got[kv.Key] = kv.Value
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("attribute %s = %v, want %v", key, got[key].Emit(), value.Emit())
+		}
+	}
+	if insert.Status.Code != codes.Unset {
+		t.Errorf("insert status = %v, want unset", insert.Status.Code)
+	}
+
+	failed := spans[2]
+	if failed.Name != "SELECT app" {
+		t.Errorf("span name = %q, want %q", failed.Name, "SELECT app")
+	}
+	if failed.Status.Code != codes.Error || failed.Status.Description == "" {
+		t.Errorf("failed status = %v %q, want error", failed.Status.Code, failed.Status.Description)
+	}
+	if len(failed.Events) == 0 || failed.Events[0].Name != "exception" {
+		t.Error("failed span has no exception event")
+	}
+	for _, kv := range failed.Attributes {
+		if kv.Key == "db.statement" && kv.Value.AsString() != "SELECT name FROM missing WHERE name = ?" {
+			t.Errorf("db.statement = %q", kv.Value.AsString())
+		}
+	}
+}
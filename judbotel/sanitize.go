@@ -0,0 +1,150 @@
+package judbotel
+
+import (
+	"strings"
+
+	"github.com/jsuserapp/judb"
+)
+
+// Sanitize 把语句中的字符串和数字常量替换为 ?, 标识符, 占位符和注释保持不变. 例如
+//
+//	SELECT * FROM users WHERE name = 'bob' AND age > 30
+//
+// 变成
+//
+//	SELECT * FROM users WHERE name = ? AND age > ?
+//
+// dialect 是 judb.DatabaseTypeMysql 等数据库类型, 字符串的规则和数据库相同: MySQL 的字符串可以使用 \ 转义,
+// 双引号包裹的也是字符串; PostgreSQL 只有 E'...' 可以使用 \ 转义, 另外支持 $tag$...$tag$ 字符串;
+// SQLite 和其它数据库只使用连续两个引号转义. 除了 MySQL, 双引号包裹的是标识符, 原样保留.
+func Sanitize(dialect, sqlCase string) string {
+	mysql := dialect == judb.DatabaseTypeMysql
+	postgres := dialect == judb.DatabaseTypePostgres
+	var builder strings.Builder
+	builder.Grow(len(sqlCase))
+	n := len(sqlCase)
+	for i := 0; i < n; {
+		c := sqlCase[i]
+		switch {
+		case c == '\'' || c == '"' && mysql:
+			i = skipString(sqlCase, i, mysql)
+			builder.WriteByte('?')
+		case postgres && (c == 'E' || c == 'e') && i+1 < n && sqlCase[i+1] == '\'' && (i == 0 || !isIdentChar(sqlCase[i-1])):
+			// PostgreSQL 的 E'...' 字符串支持 \ 转义
+			i = skipString(sqlCase, i+1, true)
+			builder.WriteByte('?')
+		case c == '"' || c == '`':
+			// 引号包裹的标识符原样保留
+			end := strings.IndexByte(sqlCase[i+1:], c)
+			if end < 0 {
+				builder.WriteString(sqlCase[i:])
+				return builder.String()
+			}
+			builder.WriteString(sqlCase[i : i+end+2])
+			i += end + 2
+		case postgres && c == '$' && i+1 < n && isDigit(sqlCase[i+1]):
+			// PostgreSQL 的占位符 $1
+			j := i + 1
+			for j < n && isDigit(sqlCase[j]) {
+				j++
+			}
+			builder.WriteString(sqlCase[i:j])
+			i = j
+		case postgres && c == '$':
+			// PostgreSQL 的 $tag$ ... $tag$ 字符串
+			end := strings.IndexByte(sqlCase[i+1:], '$')
+			tag := ""
+			if end >= 0 {
+				tag = sqlCase[i : i+end+2]
+			}
+			if tag == "" || !isTag(tag[1:len(tag)-1]) {
+				builder.WriteByte(c)
+				i++
+				continue
+			}
+			stop := strings.Index(sqlCase[i+len(tag):], tag)
+			if stop < 0 {
+				builder.WriteByte('?')
+				return builder.String()
+			}
+			builder.WriteByte('?')
+			i += len(tag) + stop + len(tag)
+		case c == '-' && i+1 < n && sqlCase[i+1] == '-':
+			end := strings.IndexByte(sqlCase[i:], '\n')
+			if end < 0 {
+				builder.WriteString(sqlCase[i:])
+				return builder.String()
+			}
+			builder.WriteString(sqlCase[i : i+end+1])
+			i += end + 1
+		case c == '/' && i+1 < n && sqlCase[i+1] == '*':
+			end := strings.Index(sqlCase[i+2:], "*/")
+			if end < 0 {
+				builder.WriteString(sqlCase[i:])
+				return builder.String()
+			}
+			builder.WriteString(sqlCase[i : i+end+4])
+			i += end + 4
+		case isDigit(c) || (c == '.' && i+1 < n && isDigit(sqlCase[i+1])):
+			// 数字常量, 包括小数, 科学计数法和十六进制
+			j := i
+			for j < n && (isIdentChar(sqlCase[j]) || sqlCase[j] == '.' ||
+				((sqlCase[j] == '+' || sqlCase[j] == '-') && (sqlCase[j-1] == 'e' || sqlCase[j-1] == 'E'))) {
+				j++
+			}
+			builder.WriteByte('?')
+			i = j
+		case isIdentChar(c):
+			// 标识符中的数字不是常量, 例如 t1, col_2
+			j := i
+			for j < n && isIdentChar(sqlCase[j]) {
+				j++
+			}
+			builder.WriteString(sqlCase[i:j])
+			i = j
+		default:
+			builder.WriteByte(c)
+			i++
+		}
+	}
+	return builder.String()
+}
+
+// skipString 跳过 i 处的引号开始的字符串, 返回结束的引号之后的位置, 没有结束的引号时返回语句的长度.
+// 连续两个引号是转义, backslash 为 true 时 \ 转义下一个字符
+func skipString(sqlCase string, i int, backslash bool) int {
+	quote := sqlCase[i]
+	n := len(sqlCase)
+	for i++; i < n; i++ {
+		switch sqlCase[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case quote:
+			if i+1 < n && sqlCase[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return n
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isTag(tag string) bool {
+	for i := 0; i < len(tag); i++ {
+		if !isIdentChar(tag[i]) || (i == 0 && isDigit(tag[i])) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+package judbotel
+
+import (
+	"testing"
+
+	"github.com/jsuserapp/judb"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		dialect string
+		sql     string
+		want    string
+	}{
+		{judb.DatabaseTypeSqlite, "SELECT * FROM users WHERE name = 'bob' AND age > 30", "SELECT * FROM users WHERE name = ? AND age > ?"},
+		{judb.DatabaseTypeSqlite, `SELECT "t1".col_2 FROM t1 WHERE x = 'it''s'`, `SELECT "t1".col_2 FROM t1 WHERE x = ?`},
+		// \ 不是转义, 第一个字符串在 \ 之后结束
+		{judb.DatabaseTypeSqlite, `SELECT 'C:\' || 'secret'`, `SELECT ? || ?`},
+		{judb.DatabaseTypePostgres, `SELECT 'C:\' || 'secret'`, `SELECT ? || ?`},
+		{judb.DatabaseTypePostgres, `SELECT E'a\'b' || 'secret' FROM "Users" WHERE id = $1`, `SELECT ? || ? FROM "Users" WHERE id = $1`},
+		{judb.DatabaseTypePostgres, "SELECT $body$ secret $body$, 1.5e-3", "SELECT ?, ?"},
+		{judb.DatabaseTypeMysql, `SELECT 'a\'b' || 'secret'`, `SELECT ? || ?`},
+		{judb.DatabaseTypeMysql, "SELECT `name` FROM users WHERE pass = \"se\\\"cret\" AND note = \"x\"\"y\"", "SELECT `name` FROM users WHERE pass = ? AND note = ?"},
+		{judb.DatabaseTypeMysql, "SELECT 1 -- 'comment'\nFROM t /* 2 */", "SELECT ? -- 'comment'\nFROM t /* 2 */"},
+		{judb.DatabaseTypeMysql, "SELECT 'unterminated", "SELECT ?"},
+	}
+	for _, tt := range tests {
+		if got := Sanitize(tt.dialect, tt.sql); got != tt.want {
+			t.Errorf("Sanitize(%s, %q) = %q, want %q", tt.dialect, tt.sql, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package judbprom 把 judb.Metrics 的统计结果导出为 Prometheus 指标.
+//
+//	metrics := judb.NewMetrics("orders", &db, nil)
+//	prometheus.MustRegister(judbprom.NewCollector(metrics))
+package judbprom
+
+import (
+	"github.com/jsuserapp/judb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queryDuration = prometheus.NewDesc("judb_query_duration_seconds",
+		"Duration of database calls by operation type.", []string{"db", "operation"}, nil)
+	queryErrors = prometheus.NewDesc("judb_query_errors_total",
+		"Failed database calls by normalized error code.", []string{"db", "code"}, nil)
+	queriesInFlight = prometheus.NewDesc("judb_queries_in_flight",
+		"Database calls currently executing.", []string{"db"}, nil)
+
+	poolMaxOpen = prometheus.NewDesc("judb_pool_max_open_connections",
+		"Maximum number of open connections to the database.", []string{"db", "pool"}, nil)
+	poolOpen = prometheus.NewDesc("judb_pool_open_connections",
+		"The number of established connections both in use and idle.", []string{"db", "pool"}, nil)
+	poolInUse = prometheus.NewDesc("judb_pool_in_use_connections",
+		"The number of connections currently in use.", []string{"db", "pool"}, nil)
+	poolIdle = prometheus.NewDesc("judb_pool_idle_connections",
+		"The number of idle connections.", []string{"db", "pool"}, nil)
+	poolWaitCount = prometheus.NewDesc("judb_pool_wait_count_total",
+		"The total number of connections waited for.", []string{"db", "pool"}, nil)
+	poolWaitDuration = prometheus.NewDesc("judb_pool_wait_duration_seconds_total",
+		"The total time blocked waiting for a new connection.", []string{"db", "pool"}, nil)
+	poolMaxIdleClosed = prometheus.NewDesc("judb_pool_max_idle_closed_total",
+		"The total number of connections closed due to SetMaxIdleConns.", []string{"db", "pool"}, nil)
+	poolMaxIdleTimeClosed = prometheus.NewDesc("judb_pool_max_idle_time_closed_total",
+		"The total number of connections closed due to SetConnMaxIdleTime.", []string{"db", "pool"}, nil)
+	poolMaxLifetimeClosed = prometheus.NewDesc("judb_pool_max_lifetime_closed_total",
+		"The total number of connections closed due to SetConnMaxLifetime.", []string{"db", "pool"}, nil)
+)
+
+type collector struct {
+	metrics []*judb.Metrics
+}
+
+// NewCollector 创建 prometheus.Collector, 可以同时导出多个数据库的统计, 它们用 db 标签区分.
+// 连接池的指标用 pool 标签区分主连接池 main 和只读连接池 read, 见 judb.MetricsSnapshot.Pools
+func NewCollector(metrics ...*judb.Metrics) prometheus.Collector {
+	return &collector{metrics: metrics}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{queryDuration, queryErrors, queriesInFlight,
+		poolMaxOpen, poolOpen, poolInUse, poolIdle, poolWaitCount, poolWaitDuration,
+		poolMaxIdleClosed, poolMaxIdleTimeClosed, poolMaxLifetimeClosed} {
+		ch <- desc
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.metrics {
+		s := m.Snapshot()
+		for op, stats := range s.Operations {
+			buckets := make(map[float64]uint64, len(s.Buckets))
+			for i, bound := range s.Buckets {
+				buckets[bound] = stats.Buckets[i]
+			}
+			ch <- prometheus.MustNewConstHistogram(queryDuration, stats.Count, stats.Sum, buckets, s.Name, op)
+		}
+		for code, count := range s.Errors {
+			ch <- prometheus.MustNewConstMetric(queryErrors, prometheus.CounterValue, float64(count), s.Name, code)
+		}
+		ch <- prometheus.MustNewConstMetric(queriesInFlight, prometheus.GaugeValue, float64(s.InFlight), s.Name)
+
+		for name, pool := range s.Pools {
+			ch <- prometheus.MustNewConstMetric(poolMaxOpen, prometheus.GaugeValue, float64(pool.MaxOpenConnections), s.Name, name)
+			ch <- prometheus.MustNewConstMetric(poolOpen, prometheus.GaugeValue, float64(pool.OpenConnections), s.Name, name)
+			ch <- prometheus.MustNewConstMetric(poolInUse, prometheus.GaugeValue, float64(pool.InUse), s.Name, name)
+			ch <- prometheus.MustNewConstMetric(poolIdle, prometheus.GaugeValue, float64(pool.Idle), s.Name, name)
+			ch <- prometheus.MustNewConstMetric(poolWaitCount, prometheus.CounterValue, float64(pool.WaitCount), s.Name, name)
+			ch <- prometheus.MustNewConstMetric(poolWaitDuration, prometheus.CounterValue, pool.WaitDuration.Seconds(), s.Name, name)
+			ch <- prometheus.MustNewConstMetric(poolMaxIdleClosed, prometheus.CounterValue, float64(pool.MaxIdleClosed), s.Name, name)
+			ch <- prometheus.MustNewConstMetric(poolMaxIdleTimeClosed, prometheus.CounterValue, float64(pool.MaxIdleTimeClosed), s.Name, name)
+			ch <- prometheus.MustNewConstMetric(poolMaxLifetimeClosed, prometheus.CounterValue, float64(pool.MaxLifetimeClosed), s.Name, name)
+		}
+	}
+}
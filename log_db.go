@@ -31,6 +31,8 @@ type _LogParam struct {
 	DbType  string
 	LogDb   *sql.DB
 	LogPath string
+	// Reporter 日志系统自身的错误输出方式, 参见 SetLogErrorReporter
+	Reporter ErrorReporter
 }
 
 func SetLogPath(path string, save bool) {
@@ -38,7 +40,7 @@ func SetLogPath(path string, save bool) {
 		path = "./data/log"
 	}
 	if !ju.CreateFolder(path) {
-		ju.OutputColor(0, ju.ColorRed, "指定的路径无法打开:", path)
+		logError(fmt.Errorf("指定的路径无法打开: %s", path))
 		return
 	}
 	logParam.LogPath = path
@@ -59,7 +61,7 @@ func init() {
 // noinspection GoUnusedExportedFunction
 func SetLogDb(dbType string, db *sql.DB, save bool) {
 	if dbType != LogDbTypeSqlite && dbType != LogDbTypeMysql && dbType != LogDbTypePostgre {
-		ju.OutputColor(0, ju.ColorRed, "不支持的数据库类型, 必须是 sqlite,mysql,postgre 之一,", dbType)
+		logError(fmt.Errorf("不支持的数据库类型, 必须是 sqlite,mysql,postgre 之一, %s", dbType))
 		return
 	}
 	logParam.DbType = dbType
@@ -129,7 +131,7 @@ func (li *LogInfo) Set(name string, count int64) {
 	}
 	_, err := logParam.LogDb.Exec(sqlCase, name, count)
 	if err != nil {
-		ju.OutputColor(0, ju.ColorRed, err.Error())
+		logError(err)
 	}
 }
 func (li *LogInfo) Load() {
@@ -146,14 +148,14 @@ func (li *LogInfo) Load() {
 	}
 	_, err := logParam.LogDb.Exec(sqlCase)
 	if err != nil {
-		ju.OutputColor(0, ju.ColorRed, err.Error())
+		logError(err)
 		return
 	}
 
 	sqlCase = "SELECT name,max_count FROM log_info"
 	rows, err := logParam.LogDb.Query(sqlCase)
 	if err != nil {
-		ju.OutputColor(0, ju.ColorRed, err.Error())
+		logError(err)
 		return
 	}
 	defer func() {
@@ -166,7 +168,7 @@ func (li *LogInfo) Load() {
 		if err == nil {
 			li.Set(name, count)
 		} else {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 			break
 		}
 	}
@@ -186,7 +188,7 @@ func saveLog(dbType, tab, trace, color, log string) {
 		logFile := filepath.Join(logParam.LogPath, tab+".log")
 		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 			return
 		}
 		defer func() {
@@ -195,7 +197,7 @@ func saveLog(dbType, tab, trace, color, log string) {
 		str := fmt.Sprintf("%s [%s] [%s] %s\r\n", ju.GetNowDateTimeMs(), trace, color, log)
 		_, err = file.WriteString(str)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 		}
 		return
 	}
@@ -213,12 +215,12 @@ func saveLog(dbType, tab, trace, color, log string) {
 		if err != nil {
 			var e SqlResult
 			e.SetError(err)
-			if (dbType == LogDbTypeMysql && e.Code == "1146") || (dbType == LogDbTypePostgre && e.Code == "42P01") || (dbType == LogDbTypeSqlite && e.Code == "") {
+			if isNoTableError(dbType, e) {
 				createLogTable(dbType, tab)
 				rows, err = logParam.LogDb.Query(sqlCase)
 			}
 			if err != nil {
-				ju.OutputColor(0, ju.ColorRed, err.Error())
+				logError(err)
 				return
 			}
 		}
@@ -229,7 +231,7 @@ func saveLog(dbType, tab, trace, color, log string) {
 		if rows.Next() {
 			err = rows.Scan(&count)
 			if err != nil {
-				ju.OutputColor(0, ju.ColorRed, err.Error())
+				logError(err)
 				return
 			}
 		}
@@ -264,7 +266,7 @@ func saveLog(dbType, tab, trace, color, log string) {
 			delCount := count - limit
 			_, err = logParam.LogDb.Exec(sqlCase, delCount)
 			if err != nil {
-				ju.OutputColor(0, ju.ColorRed, err.Error())
+				logError(err)
 				return
 			}
 			count = limit
@@ -308,7 +310,7 @@ func saveLog(dbType, tab, trace, color, log string) {
 			}
 			_, err = logParam.LogDb.Exec(sqlCase, trace, color, log, ju.GetNowDateTimeMs())
 			if err != nil {
-				ju.OutputColor(0, ju.ColorRed, err.Error())
+				logError(err)
 			}
 			return
 		}
@@ -324,15 +326,28 @@ func saveLog(dbType, tab, trace, color, log string) {
 	_, err := logParam.LogDb.Exec(sqlCase, trace, color, log, ju.GetNowDateTimeMs())
 	var e SqlResult
 	e.SetError(err)
-	if (dbType == LogDbTypeMysql && e.Code == "1146") || (dbType == LogDbTypePostgre && e.Code == "42P01") || (dbType == LogDbTypeSqlite && e.Code == "") {
+	if isNoTableError(dbType, e) {
 		createLogTable(dbType, tab)
 		_, err = logParam.LogDb.Exec(sqlCase, trace, color, log, ju.GetNowDateTimeMs())
 	}
 	if err != nil {
-		ju.OutputColor(0, ju.ColorRed, err.Error())
+		logError(err)
 		return
 	}
 }
+
+// isNoTableError 判断错误是否是表不存在, SQLite 没有单独的错误码, 只能通过错误信息判断
+func isNoTableError(dbType string, e SqlResult) bool {
+	switch dbType {
+	case LogDbTypeMysql:
+		return e.Code == "1146"
+	case LogDbTypePostgre:
+		return e.Code == "42P01"
+	case LogDbTypeSqlite:
+		return e.Code == "1" && strings.Contains(e.Error, "no such table")
+	}
+	return false
+}
 func clearLog(dbType, tab string) {
 	if dbType == LogDbTypeFile {
 		if logParam.LogPath == "" {
@@ -342,7 +357,7 @@ func clearLog(dbType, tab string) {
 		logFile := filepath.Join(logParam.LogPath, tab+".log")
 		file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_TRUNC, 0644)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 			return
 		}
 		defer func() {
@@ -358,7 +373,7 @@ func clearLog(dbType, tab string) {
 		}
 		_, err := logParam.LogDb.Exec(sqlCase)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 		}
 	}
 }
@@ -373,7 +388,7 @@ func createLogTable(dbType, tab string) {
 		}
 		_, err := logParam.LogDb.Exec(sqlCase)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 		}
 	} else if dbType == LogDbTypeSqlite {
 		sqlCase := `CREATE TABLE IF NOT EXISTS log_ (
@@ -388,7 +403,7 @@ func createLogTable(dbType, tab string) {
 		}
 		_, err := logParam.LogDb.Exec(sqlCase)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 			return
 		}
 		sqlCase = `CREATE INDEX IF NOT EXISTS idx_created_at ON log_ (created_at);`
@@ -397,7 +412,7 @@ func createLogTable(dbType, tab string) {
 		}
 		_, err = logParam.LogDb.Exec(sqlCase)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 			return
 		}
 	} else if dbType == LogDbTypePostgre {
@@ -415,7 +430,7 @@ func createLogTable(dbType, tab string) {
 
 		_, err := logParam.LogDb.Exec(sqlCase)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 		}
 		sqlCase = `CREATE INDEX "idx_created_at" ON "log_" ("created_at");`
 		if tab != "" {
@@ -423,7 +438,7 @@ func createLogTable(dbType, tab string) {
 		}
 		_, err = logParam.LogDb.Exec(sqlCase)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			logError(err)
 		}
 	}
 }
@@ -444,7 +459,7 @@ func deleteLog(dbType, tab string, idStart, idStop int64) int64 {
 	}
 	rst, err := logParam.LogDb.Exec(sqlCase, idStart, idStop)
 	if err != nil {
-		ju.OutputColor(0, ju.ColorRed, err.Error())
+		logError(err)
 		return 0
 	}
 	count, _ := rst.RowsAffected()
@@ -0,0 +1,148 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMetricsBuckets 默认的耗时分布区间, 单位秒
+var DefaultMetricsBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics 统计一个 Db 的调用次数, 耗时分布, 错误和连接池状态. 通过 Snapshot 读取统计结果,
+// 或者使用 judbprom 包把它注册到 Prometheus.
+type Metrics struct {
+	name     string
+	db       *Db
+	buckets  []float64
+	inFlight atomic.Int64
+
+	mutex      sync.Mutex
+	operations map[string]*OperationStats
+	errors     map[string]uint64
+}
+
+// OperationStats 一种语句类型的统计
+type OperationStats struct {
+	Count   uint64   // 调用次数
+	Sum     float64  // 总耗时, 单位秒
+	Buckets []uint64 // 累计分布, Buckets[i] 是耗时小于等于 MetricsSnapshot.Buckets[i] 的次数
+}
+
+// MetricsSnapshot 某一时刻的统计结果
+type MetricsSnapshot struct {
+	Name       string
+	Buckets    []float64                 // 耗时分布区间的上限, 单位秒
+	Operations map[string]OperationStats // 按语句类型统计, 类型是 select, insert, update, delete, begin, commit, rollback 或 other
+	Errors     map[string]uint64         // 按 SqlResult.Code 统计的错误次数, 没有错误码的错误记为 unknown
+	InFlight   int64                     // 正在执行的调用数
+	Pool       sql.DBStats               // 主连接池的状态, 和 Pools[MetricsPoolMain] 相同
+	// Pools 按连接池统计的状态, 主连接池是 MetricsPoolMain, 使用 SqliteOptions.ReadConns 打开的只读连接池是 MetricsPoolRead
+	Pools map[string]sql.DBStats
+}
+
+// MetricsSnapshot.Pools 中连接池的名称
+const (
+	MetricsPoolMain = "main"
+	MetricsPoolRead = "read"
+)
+
+// NewMetrics 创建统计对象并注册到 db 上, 需要在使用 Db 之前调用.
+//
+// name: 数据库名称, 用于区分多个数据库的统计.
+//
+// buckets: 耗时分布区间的上限, 单位秒, 必须递增, 为 nil 时使用 DefaultMetricsBuckets
+func NewMetrics(name string, db *Db, buckets []float64) *Metrics {
+	if buckets == nil {
+		buckets = DefaultMetricsBuckets
+	}
+	m := &Metrics{
+		name:       name,
+		db:         db,
+		buckets:    append([]float64(nil), buckets...),
+		operations: map[string]*OperationStats{},
+		errors:     map[string]uint64{},
+	}
+	sort.Float64s(m.buckets)
+	db.AddHook(m)
+	return m
+}
+
+func (m *Metrics) Before(ctx context.Context, _ *QueryEvent) context.Context {
+	m.inFlight.Add(1)
+	return ctx
+}
+
+func (m *Metrics) After(_ context.Context, event *QueryEvent) {
+	m.inFlight.Add(-1)
+	op := metricsOperation(event.Operation)
+	seconds := event.Duration.Seconds()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats := m.operations[op]
+	if stats == nil {
+		stats = &OperationStats{Buckets: make([]uint64, len(m.buckets))}
+		m.operations[op] = stats
+	}
+	stats.Count++
+	stats.Sum += seconds
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			stats.Buckets[i]++
+		}
+	}
+	if event.Result != nil && event.Result.Fail() {
+		code := event.Result.Code
+		if code == "" {
+			code = "unknown"
+		}
+		m.errors[code]++
+	}
+}
+
+// Snapshot 返回当前的统计结果, 返回值是副本, 可以自由修改
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	snapshot := MetricsSnapshot{
+		Name:       m.name,
+		Buckets:    append([]float64(nil), m.buckets...),
+		Operations: map[string]OperationStats{},
+		Errors:     map[string]uint64{},
+		InFlight:   m.inFlight.Load(),
+		Pools:      map[string]sql.DBStats{},
+	}
+	if m.db.db != nil {
+		snapshot.Pool = m.db.db.Stats()
+		snapshot.Pools[MetricsPoolMain] = snapshot.Pool
+	}
+	if m.db.readDb != nil {
+		snapshot.Pools[MetricsPoolRead] = m.db.readDb.Stats()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for op, stats := range m.operations {
+		snapshot.Operations[op] = OperationStats{
+			Count:   stats.Count,
+			Sum:     stats.Sum,
+			Buckets: append([]uint64(nil), stats.Buckets...),
+		}
+	}
+	for code, count := range m.errors {
+		snapshot.Errors[code] = count
+	}
+	return snapshot
+}
+
+// metricsOperation 把语句的关键字归类, 避免标签的取值无限增长
+func metricsOperation(operation string) string {
+	switch operation {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "BEGIN", "COMMIT", "ROLLBACK":
+		return strings.ToLower(operation)
+	case "REPLACE":
+		return "insert"
+	}
+	return "other"
+}
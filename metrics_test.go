@@ -0,0 +1,48 @@
+package judb
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMetricsSnapshotPools(t *testing.T) {
+	dir := t.TempDir()
+	opts := NewSqliteOptions()
+	opts.ReadConns = 3
+	var db Db
+	if !db.OpenSqlite3Options(filepath.Join(dir, "metrics.db"), opts) {
+		t.Fatal("open sqlite failed")
+	}
+	defer db.Close()
+	m := NewMetrics("test", &db, nil)
+	if mr := db.Exec("CREATE TABLE t (v INTEGER)"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	s := m.Snapshot()
+	if len(s.Pools) != 2 {
+		t.Fatalf("pools = %v", s.Pools)
+	}
+	if main := s.Pools[MetricsPoolMain]; main.MaxOpenConnections != 1 || main != s.Pool {
+		t.Fatalf("main pool = %+v, Pool = %+v", main, s.Pool)
+	}
+	if read := s.Pools[MetricsPoolRead]; read.MaxOpenConnections != 3 || read.OpenConnections == 0 {
+		t.Fatalf("read pool = %+v", read)
+	}
+	if s.Operations["select"].Count != 1 || s.Operations["other"].Count != 1 {
+		t.Fatalf("operations = %+v", s.Operations)
+	}
+
+	var single Db
+	if !single.OpenSqlite3(filepath.Join(dir, "single.db"), "") {
+		t.Fatal("open sqlite failed")
+	}
+	defer single.Close()
+	if s := NewMetrics("single", &single, nil).Snapshot(); len(s.Pools) != 1 || s.Pools[MetricsPoolMain] != s.Pool {
+		t.Fatalf("pools = %v", s.Pools)
+	}
+}
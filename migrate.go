@@ -0,0 +1,460 @@
+package judb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsuserapp/ju"
+)
+
+// Migration 一个版本的迁移脚本
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string // 升级脚本
+	Down     string // 回退脚本, 可以为空, 此时这个版本不能回退
+	Checksum string // 升级和回退脚本的 sha256, 任意一个被修改后都会变化
+}
+
+// MigrationStatus 一个版本的状态
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt string
+	Modified  bool // 已经执行的版本的脚本在之后被修改过
+	Missing   bool // 数据库记录已经执行, 但是找不到脚本文件
+}
+
+// Migrator 数据库迁移, 按版本号顺序执行 up 脚本, 或者按相反的顺序执行 down 脚本, 已执行的版本和校验和记录在
+// Table 指定的表中. 执行时使用数据库锁 (MySQL 的 GET_LOCK, PostgreSQL 的 pg_advisory_lock, SQLite 的
+// BEGIN IMMEDIATE), 多个实例同时启动时不会重复执行.
+//
+// 脚本文件名的格式是 <版本号>_<名称>.up.sql 和 <版本号>_<名称>.down.sql, 例如 0001_create_users.up.sql.
+// 同一个版本可以有针对数据库的脚本, 例如 0001_create_users.up.mysql.sql, 它的优先级高于通用的脚本, 其它数据库的脚本
+// 会被忽略, 数据库名称是 mysql, postgres 和 sqlite.
+//
+// 脚本中的多条语句用分号分隔, CREATE TRIGGER 的 BEGIN ... END 中的分号不会拆分. 其它包含分号的语句, 例如存储过程,
+// 需要用 -- +judb StatementBegin 和 -- +judb StatementEnd 两行注释包围. 每个版本在一个事务中执行,
+// 但是 MySQL 的 DDL 语句会隐式提交事务, 失败时可能需要手动处理.
+type Migrator struct {
+	Table       string        // 记录迁移版本的表名, 默认是 judb_migrations
+	DryRun      bool          // 只返回需要执行的版本, 不执行任何脚本, 不获取锁, 也不创建记录表
+	LockTimeout time.Duration // 等待其它实例释放锁的时间, 默认 1 分钟
+
+	db      *Db
+	scripts []migrationScript
+}
+
+// migrationScript 一个脚本文件, dialect 为空时是通用的脚本
+type migrationScript struct {
+	version int64
+	name    string
+	down    bool
+	dialect string
+	content string
+}
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+?)\.(up|down)(?:\.(mysql|postgres|sqlite))?\.sql$`)
+
+// NewMigrator 从 fsys 的 dir 目录读取迁移脚本, fsys 可以是 embed.FS, 读取磁盘目录使用 NewMigratorFromDir.
+// 使用哪一个数据库的脚本在执行时根据 db 的类型决定, 所以可以在打开数据库之前创建
+func NewMigrator(db *Db, fsys fs.FS, dir string) (*Migrator, SqlResult) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, NewSqlResult(err)
+	}
+	names := map[int64]string{}
+	mg := &Migrator{db: db}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, NewSqlResult(fmt.Errorf("无效的版本号: %s", entry.Name()))
+		}
+		if name, ok := names[version]; ok && name != match[2] {
+			return nil, NewSqlResult(fmt.Errorf("版本 %d 有两个不同的名称: %s 和 %s", version, name, match[2]))
+		}
+		names[version] = match[2]
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, NewSqlResult(err)
+		}
+		mg.scripts = append(mg.scripts, migrationScript{
+			version: version,
+			name:    match[2],
+			down:    match[3] == "down",
+			dialect: match[4],
+			content: string(data),
+		})
+	}
+	return mg, SqlResult{}
+}
+
+// NewMigratorFromDir 从磁盘目录读取迁移脚本
+func NewMigratorFromDir(db *Db, dir string) (*Migrator, SqlResult) {
+	return NewMigrator(db, os.DirFS(dir), ".")
+}
+
+// Migrations 返回当前数据库使用的迁移脚本, 按版本号排序. 某个版本缺少 up 脚本时返回 nil, 错误由 Status 和 Up 等返回
+func (mg *Migrator) Migrations() []*Migration {
+	migrations, err := mg.resolve()
+	if err != nil {
+		return nil
+	}
+	return migrations
+}
+
+// resolve 按数据库的类型选择每个版本的脚本, 数据库专用的脚本优先于通用的脚本, 其它数据库的脚本被忽略
+func (mg *Migrator) resolve() ([]*Migration, error) {
+	dialect := mg.db.dbType
+	migrations := map[int64]*Migration{}
+	ups := map[int64]migrationScript{}
+	downs := map[int64]migrationScript{}
+	for _, script := range mg.scripts {
+		if script.dialect != "" && script.dialect != dialect {
+			continue
+		}
+		if migrations[script.version] == nil {
+			migrations[script.version] = &Migration{Version: script.version, Name: script.name}
+		}
+		scripts := ups
+		if script.down {
+			scripts = downs
+		}
+		if old, ok := scripts[script.version]; ok && old.dialect != "" && script.dialect == "" {
+			continue
+		}
+		scripts[script.version] = script
+	}
+
+	var list []*Migration
+	for version, m := range migrations {
+		up, ok := ups[version]
+		if !ok {
+			return nil, fmt.Errorf("版本 %d 缺少 up 脚本", version)
+		}
+		m.Up = up.content
+		m.Down = downs[version].content
+		// 两个脚本之间用 NUL 分隔, 内容在两个脚本之间移动时校验和也会变化
+		sum := sha256.Sum256([]byte(m.Up + "\x00" + m.Down))
+		m.Checksum = hex.EncodeToString(sum[:])
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list, nil
+}
+
+// Status 返回所有版本的状态, 按版本号排序. Status 只读取记录, 不获取锁, 记录表不存在时所有版本都是未执行
+func (mg *Migrator) Status() ([]MigrationStatus, SqlResult) {
+	ctx := context.Background()
+	conn, err := mg.db.db.Conn(ctx)
+	if err != nil {
+		return nil, NewSqlResult(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	applied, err := mg.applied(ctx, conn)
+	if err != nil {
+		return nil, NewSqlResult(err)
+	}
+	migrations, err := mg.resolve()
+	if err != nil {
+		return nil, NewSqlResult(err)
+	}
+	var list []MigrationStatus
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if record, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.appliedAt
+			status.Modified = record.checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		list = append(list, status)
+	}
+	for version, record := range applied {
+		list = append(list, MigrationStatus{Version: version, Name: record.name, Applied: true, AppliedAt: record.appliedAt, Missing: true})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list, SqlResult{}
+}
+
+// Up 执行所有未执行的版本, 返回执行了的版本
+func (mg *Migrator) Up() ([]*Migration, SqlResult) {
+	return mg.UpTo(-1)
+}
+
+// UpTo 执行版本号小于等于 version 的未执行版本, version 为负数时执行所有版本. 已执行的脚本被修改过时不会执行任何版本
+func (mg *Migrator) UpTo(version int64) ([]*Migration, SqlResult) {
+	return mg.run(func(migrations []*Migration, applied map[int64]migrationRecord) ([]*Migration, error) {
+		var pending []*Migration
+		for _, m := range migrations {
+			record, ok := applied[m.Version]
+			if ok {
+				if record.checksum != m.Checksum {
+					return nil, fmt.Errorf("版本 %d_%s 执行后被修改过", m.Version, m.Name)
+				}
+				continue
+			}
+			if version < 0 || m.Version <= version {
+				pending = append(pending, m)
+			}
+		}
+		return pending, nil
+	}, true)
+}
+
+// DownTo 按版本号从大到小回退所有版本号大于 version 的已执行版本, version 为 0 时回退所有版本
+func (mg *Migrator) DownTo(version int64) ([]*Migration, SqlResult) {
+	return mg.run(func(migrations []*Migration, applied map[int64]migrationRecord) ([]*Migration, error) {
+		var pending []*Migration
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.Version <= version {
+				break
+			}
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if strings.TrimSpace(m.Down) == "" {
+				return nil, fmt.Errorf("版本 %d_%s 没有 down 脚本, 不能回退", m.Version, m.Name)
+			}
+			pending = append(pending, m)
+		}
+		for v := range applied {
+			if v > version && findMigration(migrations, v) == nil {
+				return nil, fmt.Errorf("版本 %d 已经执行, 但是找不到脚本, 不能回退", v)
+			}
+		}
+		return pending, nil
+	}, false)
+}
+
+func findMigration(migrations []*Migration, version int64) *Migration {
+	for _, m := range migrations {
+		if m.Version == version {
+			return m
+		}
+	}
+	return nil
+}
+
+type migrationRecord struct {
+	name      string
+	checksum  string
+	appliedAt string
+}
+
+// run 获取锁后创建记录表并读取已执行的版本, 由 plan 决定需要执行的版本, 然后逐个执行
+func (mg *Migrator) run(plan func([]*Migration, map[int64]migrationRecord) ([]*Migration, error), up bool) ([]*Migration, SqlResult) {
+	ctx := context.Background()
+	conn, err := mg.db.db.Conn(ctx)
+	if err != nil {
+		return nil, NewSqlResult(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	migrations, err := mg.resolve()
+	if err != nil {
+		return nil, NewSqlResult(err)
+	}
+	// DryRun 不修改数据库, 只读取记录
+	if !mg.DryRun {
+		// 先获取锁再创建记录表, 多个实例同时启动时 PostgreSQL 的 CREATE TABLE IF NOT EXISTS 可能因为并发创建而失败
+		unlock, err := mg.lock(ctx, conn)
+		if err != nil {
+			return nil, NewSqlResult(err)
+		}
+		defer unlock()
+		if err = mg.createTable(ctx, conn); err != nil {
+			return nil, NewSqlResult(err)
+		}
+	}
+
+	applied, err := mg.applied(ctx, conn)
+	if err != nil {
+		return nil, NewSqlResult(err)
+	}
+	pending, err := plan(migrations, applied)
+	if err != nil || mg.DryRun {
+		return pending, NewSqlResult(err)
+	}
+	var done []*Migration
+	for _, m := range pending {
+		applied, err := mg.apply(ctx, conn, m, up)
+		if err != nil {
+			direction := "up"
+			if !up {
+				direction = "down"
+			}
+			return done, NewSqlResult(fmt.Errorf("版本 %d_%s %s 失败: %w", m.Version, m.Name, direction, err))
+		}
+		if applied {
+			done = append(done, m)
+		}
+	}
+	return done, SqlResult{}
+}
+
+func (mg *Migrator) table() string {
+	if mg.Table == "" {
+		return "judb_migrations"
+	}
+	return mg.Table
+}
+
+func (mg *Migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	sqlCase := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL, name VARCHAR(255) NOT NULL, checksum VARCHAR(64) NOT NULL, applied_at VARCHAR(32) NOT NULL, PRIMARY KEY (version))",
+		mg.db.QuoteIdent(mg.table()))
+	_, err := conn.ExecContext(ctx, sqlCase)
+	return err
+}
+
+// applied 读取已执行的版本, 记录表不存在时返回空的结果
+func (mg *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]migrationRecord, error) {
+	applied := map[int64]migrationRecord{}
+	exists, err := mg.tableExists(ctx, conn)
+	if err != nil || !exists {
+		return applied, err
+	}
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s", mg.db.QuoteIdent(mg.table())))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var version int64
+		var record migrationRecord
+		if err = rows.Scan(&version, &record.name, &record.checksum, &record.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = record
+	}
+	return applied, rows.Err()
+}
+
+func (mg *Migrator) tableExists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var sqlCase string
+	switch mg.db.dbType {
+	case DatabaseTypeMysql:
+		sqlCase = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	case DatabaseTypePostgres:
+		sqlCase = "SELECT COUNT(*) FROM (SELECT to_regclass(quote_ident($1)) AS t) r WHERE t IS NOT NULL"
+	default:
+		sqlCase = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	}
+	var count int
+	err := conn.QueryRowContext(ctx, sqlCase, mg.table()).Scan(&count)
+	return count > 0, err
+}
+
+// lock 获取数据库级别的锁, SQLite 没有这种锁, 由 apply 中的 BEGIN IMMEDIATE 和版本号主键保证不会重复执行
+func (mg *Migrator) lock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	timeout := mg.LockTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	name := "judb_migrate_" + mg.table()
+	switch mg.db.dbType {
+	case DatabaseTypeMysql:
+		var got sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(timeout.Seconds())).Scan(&got)
+		if err != nil {
+			return nil, err
+		}
+		if got.Int64 != 1 {
+			return nil, fmt.Errorf("等待迁移锁 %s 超时", name)
+		}
+		return func() {
+			_, _ = conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+		}, nil
+	case DatabaseTypePostgres:
+		key := int64(crc32.ChecksumIEEE([]byte(name)))
+		lockCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			return nil, err
+		}
+		return func() {
+			_, _ = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		}, nil
+	}
+	return func() {}, nil
+}
+
+// apply 在一个事务中执行一个版本的脚本并修改记录, 版本已经被其它实例处理时返回 false
+func (mg *Migrator) apply(ctx context.Context, conn *sql.Conn, m *Migration, up bool) (applied bool, err error) {
+	begin := "BEGIN"
+	if mg.db.dbType == DatabaseTypeSqlite {
+		// 立即获取写锁, 其它实例在这里等待, 然后通过下面的检查发现版本已经执行
+		begin = "BEGIN IMMEDIATE"
+	}
+	if _, err = conn.ExecContext(ctx, begin); err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	table := mg.db.QuoteIdent(mg.table())
+	var count int
+	err = conn.QueryRowContext(ctx, mg.db.Rebind(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE version = ?", table)), m.Version).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if (count > 0) == up {
+		// 其它实例已经处理了这个版本
+		_, err = conn.ExecContext(ctx, "COMMIT")
+		return false, err
+	}
+
+	script := m.Up
+	if !up {
+		script = m.Down
+	}
+	for _, statement := range splitStatements(mg.db.dbType, script) {
+		if _, err = conn.ExecContext(ctx, statement); err != nil {
+			return false, err
+		}
+	}
+	if up {
+		_, err = conn.ExecContext(ctx, mg.db.Rebind(fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)", table)),
+			m.Version, m.Name, m.Checksum, ju.GetNowDateTimeMs())
+	} else {
+		_, err = conn.ExecContext(ctx, mg.db.Rebind(fmt.Sprintf("DELETE FROM %s WHERE version = ?", table)), m.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	return err == nil, err
+}
@@ -0,0 +1,197 @@
+package judb
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func openMigrateDb(t *testing.T) *Db {
+	t.Helper()
+	db := &Db{}
+	if !db.OpenSqlite3(filepath.Join(t.TempDir(), "migrate.db"), "") {
+		t.Fatal("open sqlite failed")
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// migrationFiles 文件名按字符串排序是 10, 1, 2, 执行顺序需要按数字排序
+func migrationFiles() fstest.MapFS {
+	return fstest.MapFS{
+		"m/1_log.up.sql":    {Data: []byte("CREATE TABLE log (v INTEGER); INSERT INTO log VALUES (1);")},
+		"m/1_log.down.sql":  {Data: []byte("DROP TABLE log;")},
+		"m/2_two.up.sql":    {Data: []byte("INSERT INTO log VALUES (2);")},
+		"m/2_two.down.sql":  {Data: []byte("INSERT INTO log VALUES (-2);")},
+		"m/10_ten.up.sql":   {Data: []byte("CREATE TRIGGER log_ai AFTER INSERT ON log WHEN new.v = 99 BEGIN INSERT INTO log VALUES (100); END; INSERT INTO log VALUES (10);")},
+		"m/10_ten.down.sql": {Data: []byte("DROP TRIGGER log_ai; INSERT INTO log VALUES (-10);")},
+		"m/README.md":       {Data: []byte("not a migration")},
+	}
+}
+
+func migrationVersions(migrations []*Migration) []int64 {
+	var versions []int64
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
+
+func logValues(t *testing.T, db *Db) []int64 {
+	t.Helper()
+	var values []int64
+	err := db.collect("SELECT v FROM log ORDER BY rowid", nil, func(rows *sql.Rows) error {
+		var v int64
+		err := rows.Scan(&v)
+		values = append(values, v)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	db := openMigrateDb(t)
+	mg, mr := NewMigrator(db, migrationFiles(), "m")
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	done, mr := mg.Up()
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if versions := migrationVersions(done); !reflect.DeepEqual(versions, []int64{1, 2, 10}) {
+		t.Fatalf("Up = %v, want [1 2 10]", versions)
+	}
+	if values := logValues(t, db); !reflect.DeepEqual(values, []int64{1, 2, 10}) {
+		t.Fatalf("log = %v, want [1 2 10]", values)
+	}
+	// 触发器的 BEGIN ... END 作为一条语句执行
+	if mr := db.Exec("INSERT INTO log VALUES (99)"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if done, mr = mg.Up(); mr.Fail() || len(done) != 0 {
+		t.Fatalf("second Up = %v, %s", migrationVersions(done), mr.Error)
+	}
+
+	done, mr = mg.DownTo(1)
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if versions := migrationVersions(done); !reflect.DeepEqual(versions, []int64{10, 2}) {
+		t.Fatalf("DownTo(1) = %v, want [10 2]", versions)
+	}
+	if values := logValues(t, db); !reflect.DeepEqual(values, []int64{1, 2, 10, 99, 100, -10, -2}) {
+		t.Fatalf("log = %v", values)
+	}
+	status, mr := mg.Status()
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	var applied []int64
+	for _, s := range status {
+		if s.Applied {
+			applied = append(applied, s.Version)
+		}
+	}
+	if !reflect.DeepEqual(applied, []int64{1}) || len(status) != 3 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	if done, mr = mg.UpTo(2); mr.Fail() || !reflect.DeepEqual(migrationVersions(done), []int64{2}) {
+		t.Fatalf("UpTo(2) = %v, %s", migrationVersions(done), mr.Error)
+	}
+	if done, mr = mg.DownTo(0); mr.Fail() || !reflect.DeepEqual(migrationVersions(done), []int64{2, 1}) {
+		t.Fatalf("DownTo(0) = %v, %s", migrationVersions(done), mr.Error)
+	}
+}
+
+func TestMigratorReadOnlyModes(t *testing.T) {
+	db := openMigrateDb(t)
+	mg, mr := NewMigrator(db, migrationFiles(), "m")
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	status, mr := mg.Status()
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if len(status) != 3 || status[0].Applied || status[1].Applied || status[2].Applied {
+		t.Fatalf("status = %+v", status)
+	}
+	mg.DryRun = true
+	pending, mr := mg.Up()
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if versions := migrationVersions(pending); !reflect.DeepEqual(versions, []int64{1, 2, 10}) {
+		t.Fatalf("DryRun Up = %v", versions)
+	}
+	tables, mr := db.Tables()
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if len(tables) != 0 {
+		t.Fatalf("Status and DryRun created tables %v", tables)
+	}
+}
+
+func TestMigratorChecksum(t *testing.T) {
+	db := openMigrateDb(t)
+	files := migrationFiles()
+	mg, mr := NewMigrator(db, files, "m")
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if _, mr = mg.Up(); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+
+	// 只修改 down 脚本同样被发现
+	files["m/2_two.down.sql"] = &fstest.MapFile{Data: []byte("DELETE FROM log WHERE v = 2;")}
+	mg, mr = NewMigrator(db, files, "m")
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	status, mr := mg.Status()
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	for _, s := range status {
+		if s.Modified != (s.Version == 2) {
+			t.Fatalf("status = %+v", status)
+		}
+	}
+	if _, mr = mg.Up(); !mr.Fail() {
+		t.Fatal("Up succeeded with a modified migration")
+	}
+}
+
+func TestMigratorDialectScripts(t *testing.T) {
+	db := openMigrateDb(t)
+	files := fstest.MapFS{
+		"1_t.up.sql":          {Data: []byte("CREATE TABLE generic (v INTEGER);")},
+		"1_t.up.sqlite.sql":   {Data: []byte("CREATE TABLE lite (v INTEGER);")},
+		"1_t.up.mysql.sql":    {Data: []byte("CREATE TABLE my (v INT) ENGINE=InnoDB;")},
+		"2_u.up.postgres.sql": {Data: []byte("CREATE TABLE pg (v int);")},
+		"2_u.up.sql":          {Data: []byte("CREATE TABLE u (v INTEGER);")},
+	}
+	mg, mr := NewMigrator(db, files, ".")
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if _, mr = mg.Up(); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	tables, mr := db.Tables()
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if want := []string{"judb_migrations", "lite", "u"}; !reflect.DeepEqual(tables, want) {
+		t.Fatalf("tables = %v, want %v", tables, want)
+	}
+}
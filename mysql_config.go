@@ -0,0 +1,208 @@
+package judb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jsuserapp/ju"
+)
+
+// MysqlOptions MySQL 连接参数, 覆盖了常用的 DSN 参数. 建议使用 NewMysqlOptions 创建, 它会设置推荐的默认值,
+// 然后通过 MakeConfig 生成 mysql.Config 用于 OpenMysql. 已有的 DSN 字符串可以使用 ParseMysqlDSN 解析成这个结构.
+type MysqlOptions struct {
+	Host     string
+	Port     int    // 0 表示默认端口 3306
+	Socket   string // Unix socket 路径, 设置后使用 unix 网络, 忽略 Host 和 Port
+	DbName   string
+	User     string
+	Password string
+
+	Charset   string // 连接字符集, 例如 utf8mb4
+	Collation string // 连接排序规则, 例如 utf8mb4_unicode_ci, 必须属于 Charset
+
+	ParseTime bool           // DATE 和 DATETIME 列返回 time.Time, 否则返回 []byte
+	Loc       *time.Location // time.Time 的时区, nil 表示 UTC
+
+	Timeout      time.Duration // 建立连接的超时时间, 0 表示使用系统默认值
+	ReadTimeout  time.Duration // 读超时, 0 表示不限制
+	WriteTimeout time.Duration // 写超时, 0 表示不限制
+
+	MultiStatements   bool // 允许一次执行多条语句, 有 SQL 注入风险, 只在必要时打开
+	InterpolateParams bool // 在客户端替换占位符, 减少一次网络往返
+	MaxAllowedPacket  int  // 最大包大小, 单位字节, 0 表示使用驱动默认值 64MB
+
+	TLSName string            // mysql.RegisterTLSConfig 注册的名称, 或者 true, false, skip-verify, preferred
+	Params  map[string]string // 其它连接参数, 会作为系统变量在连接时设置
+}
+
+// NewMysqlOptions 创建 MySQL 连接参数, 默认使用 utf8mb4 字符集, 解析时间到 time.Time, 时区为 UTC
+func NewMysqlOptions(host string, port int, dbname, user, pass string) *MysqlOptions {
+	return &MysqlOptions{
+		Host:      host,
+		Port:      port,
+		DbName:    dbname,
+		User:      user,
+		Password:  pass,
+		Charset:   "utf8mb4",
+		ParseTime: true,
+		Loc:       time.UTC,
+	}
+}
+
+var mysqlNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Validate 检查参数是否有效
+func (opts *MysqlOptions) Validate() error {
+	if opts.Socket == "" {
+		if opts.Host == "" {
+			return errors.New("mysql: 必须设置 Host 或者 Socket")
+		}
+		if opts.Port < 0 || opts.Port > 65535 {
+			return fmt.Errorf("mysql: 无效的端口 %d", opts.Port)
+		}
+	}
+	if opts.User == "" {
+		return errors.New("mysql: 用户名不能为空")
+	}
+	if opts.Charset != "" && !mysqlNamePattern.MatchString(opts.Charset) {
+		return fmt.Errorf("mysql: 无效的字符集 %q", opts.Charset)
+	}
+	if opts.Collation != "" {
+		if !mysqlNamePattern.MatchString(opts.Collation) {
+			return fmt.Errorf("mysql: 无效的排序规则 %q", opts.Collation)
+		}
+		if opts.Charset != "" && opts.Collation != opts.Charset && !strings.HasPrefix(opts.Collation, opts.Charset+"_") {
+			return fmt.Errorf("mysql: 排序规则 %s 不属于字符集 %s", opts.Collation, opts.Charset)
+		}
+	}
+	if opts.Timeout < 0 || opts.ReadTimeout < 0 || opts.WriteTimeout < 0 {
+		return errors.New("mysql: 超时时间不能是负数")
+	}
+	if opts.MaxAllowedPacket < 0 || opts.MaxAllowedPacket > 1<<30 {
+		return fmt.Errorf("mysql: 无效的 MaxAllowedPacket %d, 有效范围是 0 到 1GB", opts.MaxAllowedPacket)
+	}
+	// 其余的参数组合, 例如 InterpolateParams 和不安全的排序规则, 交给驱动检查
+	_, err := mysql.ParseDSN(opts.build().FormatDSN())
+	return err
+}
+
+// MakeConfig 生成 mysql.Config, 参数无效时返回 nil
+func (opts *MysqlOptions) MakeConfig() *mysql.Config {
+	if ju.OutputErrorTrace(opts.Validate(), 1) {
+		return nil
+	}
+	return opts.build()
+}
+
+// FormatDSN 生成 DSN 字符串, 参数无效时返回空串
+func (opts *MysqlOptions) FormatDSN() string {
+	if ju.OutputErrorTrace(opts.Validate(), 1) {
+		return ""
+	}
+	return opts.build().FormatDSN()
+}
+
+func (opts *MysqlOptions) build() *mysql.Config {
+	cfg := mysql.NewConfig()
+	if opts.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = opts.Socket
+	} else {
+		port := opts.Port
+		if port == 0 {
+			port = 3306
+		}
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(opts.Host, strconv.Itoa(port))
+	}
+	cfg.DBName = opts.DbName
+	cfg.User = opts.User
+	cfg.Passwd = opts.Password
+	if opts.Charset != "" {
+		_ = cfg.Apply(mysql.Charset(opts.Charset, opts.Collation))
+	} else {
+		cfg.Collation = opts.Collation
+	}
+	cfg.ParseTime = opts.ParseTime
+	if opts.Loc != nil {
+		cfg.Loc = opts.Loc
+	}
+	cfg.Timeout = opts.Timeout
+	cfg.ReadTimeout = opts.ReadTimeout
+	cfg.WriteTimeout = opts.WriteTimeout
+	cfg.MultiStatements = opts.MultiStatements
+	cfg.InterpolateParams = opts.InterpolateParams
+	if opts.MaxAllowedPacket > 0 {
+		cfg.MaxAllowedPacket = opts.MaxAllowedPacket
+	}
+	cfg.TLSConfig = opts.TLSName
+	if len(opts.Params) > 0 {
+		cfg.Params = make(map[string]string, len(opts.Params))
+		for key, val := range opts.Params {
+			cfg.Params[key] = val
+		}
+	}
+	return cfg
+}
+
+// ParseMysqlDSN 解析 DSN 字符串, 例如 user:pass@tcp(127.0.0.1:3306)/dbname?parseTime=true&charset=utf8mb4
+func ParseMysqlDSN(dsn string) (*MysqlOptions, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	opts := &MysqlOptions{
+		DbName:            cfg.DBName,
+		User:              cfg.User,
+		Password:          cfg.Passwd,
+		Collation:         cfg.Collation,
+		ParseTime:         cfg.ParseTime,
+		Loc:               cfg.Loc,
+		Timeout:           cfg.Timeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		MultiStatements:   cfg.MultiStatements,
+		InterpolateParams: cfg.InterpolateParams,
+		MaxAllowedPacket:  cfg.MaxAllowedPacket,
+		TLSName:           cfg.TLSConfig,
+	}
+	if cfg.Net == "unix" {
+		opts.Socket = cfg.Addr
+	} else {
+		host, port, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return nil, err
+		}
+		opts.Host = host
+		opts.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: 无效的端口 %q", port)
+		}
+	}
+	// 驱动不公开解析后的字符集, 也不放入 Params, 从驱动重新生成的 DSN 中读取, 多个候选字符集时取第一个.
+	// 生成的 DSN 中库名和参数值都经过转义, 最后一个 / 之后的第一个 ? 就是参数部分的开始, 密码中的 / 和 ? 不影响
+	formatted := cfg.FormatDSN()
+	tail := formatted[strings.LastIndexByte(formatted, '/')+1:]
+	if pos := strings.IndexByte(tail, '?'); pos >= 0 {
+		query, err := url.ParseQuery(tail[pos+1:])
+		if err == nil {
+			if charsets := query.Get("charset"); charsets != "" {
+				opts.Charset = strings.Split(charsets, ",")[0]
+			}
+		}
+	}
+	if len(cfg.Params) > 0 {
+		opts.Params = make(map[string]string, len(cfg.Params))
+		for key, val := range cfg.Params {
+			opts.Params[key] = val
+		}
+	}
+	return opts, opts.Validate()
+}
@@ -0,0 +1,132 @@
+package judb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMysqlOptionsRoundTrip(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		name string
+		opts *MysqlOptions
+	}{
+		{name: "defaults", opts: NewMysqlOptions("127.0.0.1", 3306, "app", "root", "secret")},
+		{
+			name: "all options",
+			opts: &MysqlOptions{
+				Host:              "db.example.com",
+				Port:              3307,
+				DbName:            "app",
+				User:              "app",
+				Password:          "p@ss/w?rd",
+				Charset:           "utf8mb4",
+				Collation:         "utf8mb4_unicode_ci",
+				ParseTime:         true,
+				Loc:               shanghai,
+				Timeout:           5 * time.Second,
+				ReadTimeout:       30 * time.Second,
+				WriteTimeout:      time.Minute,
+				MultiStatements:   true,
+				InterpolateParams: true,
+				MaxAllowedPacket:  16 << 20,
+				TLSName:           "preferred",
+				Params:            map[string]string{"sql_mode": "'STRICT_ALL_TABLES'"},
+			},
+		},
+		{
+			name: "socket",
+			opts: &MysqlOptions{Socket: "/var/run/mysqld/mysqld.sock", DbName: "app", User: "root", Loc: time.UTC, MaxAllowedPacket: 4 << 20},
+		},
+		{
+			name: "ipv6",
+			opts: &MysqlOptions{Host: "::1", Port: 3306, DbName: "app", User: "root", Charset: "latin1", Loc: time.UTC, MaxAllowedPacket: 4 << 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.opts.MaxAllowedPacket == 0 {
+				// 解析时得到驱动的默认值
+				tt.opts.MaxAllowedPacket = 64 << 20
+			}
+			dsn := tt.opts.FormatDSN()
+			if dsn == "" {
+				t.Fatal("FormatDSN failed")
+			}
+			parsed, err := ParseMysqlDSN(dsn)
+			if err != nil {
+				t.Fatalf("ParseMysqlDSN(%q): %v", dsn, err)
+			}
+			if !reflect.DeepEqual(parsed, tt.opts) {
+				t.Fatalf("ParseMysqlDSN(%q) = %+v, want %+v", dsn, parsed, tt.opts)
+			}
+		})
+	}
+}
+
+func TestParseMysqlDSNCharset(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		charset string
+	}{
+		{"root:secret@tcp(127.0.0.1:3306)/app", ""},
+		{"root:secret@tcp(127.0.0.1:3306)/app?charset=utf8mb4", "utf8mb4"},
+		{"root:secret@tcp(127.0.0.1:3306)/app?charset=utf8mb4,utf8&parseTime=true", "utf8mb4"},
+		// 密码和库名中的 / 和 ? 不影响参数部分的查找
+		{"root:a/b?charset=x@tcp(127.0.0.1:3306)/app?charset=latin1", "latin1"},
+		{"root:a/b?c@tcp(127.0.0.1:3306)/app", ""},
+		{"root:secret@tcp(127.0.0.1:3306)/app%3Fcharset=x?charset=gbk", "gbk"},
+	}
+	for _, tt := range tests {
+		opts, err := ParseMysqlDSN(tt.dsn)
+		if err != nil {
+			t.Fatalf("ParseMysqlDSN(%q): %v", tt.dsn, err)
+		}
+		if opts.Charset != tt.charset {
+			t.Fatalf("ParseMysqlDSN(%q).Charset = %q, want %q", tt.dsn, opts.Charset, tt.charset)
+		}
+	}
+}
+
+func TestMysqlOptionsValidate(t *testing.T) {
+	valid := func() *MysqlOptions {
+		return NewMysqlOptions("127.0.0.1", 3306, "app", "root", "secret")
+	}
+	tests := []struct {
+		name   string
+		modify func(opts *MysqlOptions)
+	}{
+		{"no host", func(opts *MysqlOptions) { opts.Host = "" }},
+		{"bad port", func(opts *MysqlOptions) { opts.Port = 70000 }},
+		{"no user", func(opts *MysqlOptions) { opts.User = "" }},
+		{"bad charset", func(opts *MysqlOptions) { opts.Charset = "utf8mb4&x=y" }},
+		{"bad collation", func(opts *MysqlOptions) { opts.Collation = "a b" }},
+		{"collation of another charset", func(opts *MysqlOptions) { opts.Collation = "latin1_swedish_ci" }},
+		{"negative timeout", func(opts *MysqlOptions) { opts.ReadTimeout = -time.Second }},
+		{"packet too large", func(opts *MysqlOptions) { opts.MaxAllowedPacket = 2 << 30 }},
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		opts := valid()
+		tt.modify(opts)
+		if err := opts.Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded", tt.name)
+		}
+	}
+	if _, err := ParseMysqlDSN("root@tcp(127.0.0.1:3306)/app?charset=utf8mb4&collation=latin1_swedish_ci"); err == nil {
+		t.Error("ParseMysqlDSN accepted a collation of another charset")
+	}
+}
+
+func TestMakeMysqlConfigLegacyDefaults(t *testing.T) {
+	cfg := MakeMysqlConfig("127.0.0.1", "3306", "app", "root", "secret")
+	if cfg.ParseTime || cfg.Loc != nil {
+		t.Fatalf("MakeMysqlConfig changed time handling: ParseTime=%v Loc=%v", cfg.ParseTime, cfg.Loc)
+	}
+}
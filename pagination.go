@@ -0,0 +1,342 @@
+package judb
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPageSize PageQuery.Size 没有设置时使用的每页数量
+const DefaultPageSize = 20
+
+// PageQuery 分页查询的参数.
+//
+// 设置了 Keys 时使用键集分页, 翻页使用上一页返回的 NextCursor 或 PrevCursor, 深度翻页的性能和第一页相同,
+// Keys 中最后一列必须是唯一的, 通常是主键, 排序列的值不能是 NULL. 没有设置 Keys 时使用偏移分页, 按 Page 和 Size 计算 OFFSET.
+type PageQuery struct {
+	SQL       string        // 基础查询, 不包含 ORDER BY 和 LIMIT, 占位符使用 ?, 会按数据库类型转换
+	Args      []interface{} // 基础查询的参数
+	Size      int           // 每页数量, 默认 DefaultPageSize
+	WithTotal bool          // 是否查询总数, 会执行一次 COUNT(*)
+
+	Page    int    // 偏移分页的页码, 从 1 开始
+	OrderBy string // 偏移分页的排序, 例如 "created_at DESC, id DESC", 列名是基础查询结果中的列名
+
+	Keys   []PageKey // 键集分页的排序列
+	Cursor string    // 键集分页的游标, 为空时返回第一页
+}
+
+// PageKey 键集分页的一个排序列, Column 是基础查询结果中的列名
+type PageKey struct {
+	Column string
+	Desc   bool
+}
+
+// PageInfo 分页的结果信息
+type PageInfo struct {
+	Page       int   // 偏移分页的页码, 键集分页是 0
+	Size       int   // 每页数量
+	Total      int64 // 总数, 没有设置 WithTotal 时是 -1
+	HasNext    bool
+	HasPrev    bool
+	NextCursor string // 键集分页下一页的游标, 没有下一页时为空
+	PrevCursor string // 键集分页上一页的游标, 没有上一页时为空
+}
+
+// Page 一页数据
+type Page[T any] struct {
+	PageInfo
+	Items []T
+}
+
+// Paginate 执行分页查询, scan 从当前行读取一条数据, 例如:
+//
+//	page, mr := judb.Paginate(db, judb.PageQuery{SQL: "SELECT id, name FROM users", Keys: []judb.PageKey{{Column: "id"}}},
+//		func(rows *sql.Rows) (User, error) {
+//			var u User
+//			err := rows.Scan(&u.Id, &u.Name)
+//			return u, err
+//		})
+func Paginate[T any](db *Db, q PageQuery, scan func(rows *sql.Rows) (T, error)) (*Page[T], SqlResult) {
+	page, err := paginate(db, q, scan)
+	if err != nil {
+		db.reportError(errSkip, err)
+		return nil, NewSqlResult(err)
+	}
+	return page, SqlResult{}
+}
+
+func paginate[T any](db *Db, q PageQuery, scan func(rows *sql.Rows) (T, error)) (*Page[T], error) {
+	if db.db == nil {
+		return nil, errors.New("数据库没有打开")
+	}
+	if q.Size <= 0 {
+		q.Size = DefaultPageSize
+	}
+	page := &Page[T]{PageInfo: PageInfo{Size: q.Size, Total: -1}}
+	if q.WithTotal {
+		if err := db.QueryRow(db.Rebind("SELECT COUNT(*) FROM ("+q.SQL+") judb_count"), q.Args...).Scan(&page.Total); err != nil {
+			return nil, err
+		}
+	}
+
+	var sqlCase string
+	var args []interface{}
+	backward := false
+	if len(q.Keys) == 0 {
+		if q.Page <= 0 {
+			q.Page = 1
+		}
+		page.Page = q.Page
+		sqlCase, args = offsetPageSQL(q)
+	} else {
+		var cursor *pageCursor
+		if q.Cursor != "" {
+			var err error
+			if cursor, err = decodePageCursor(q.Cursor, len(q.Keys)); err != nil {
+				return nil, err
+			}
+			backward = cursor.Backward
+		}
+		sqlCase, args = keysetPageSQL(db.dbType, q, cursor)
+	}
+
+	var keyIndex []int
+	var keys [][]interface{}
+	err := db.query(db.Rebind(sqlCase), func(rows *sql.Rows) error {
+		var err error
+		if len(q.Keys) > 0 {
+			if keyIndex, err = pageKeyIndex(rows, q.Keys); err != nil {
+				return err
+			}
+		}
+		for rows.Next() {
+			if len(page.Items) == q.Size {
+				// 多查询的一行只用来判断是否还有下一页
+				if backward {
+					page.HasPrev = true
+				} else {
+					page.HasNext = true
+				}
+				break
+			}
+			item, err := scan(rows)
+			if err != nil {
+				return err
+			}
+			page.Items = append(page.Items, item)
+			if keyIndex != nil {
+				key, err := pageKeyValues(rows, keyIndex)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, key)
+			}
+		}
+		return rows.Err()
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(q.Keys) == 0 {
+		page.HasPrev = q.Page > 1
+		return page, nil
+	}
+	if backward {
+		for i, j := 0, len(page.Items)-1; i < j; i, j = i+1, j-1 {
+			page.Items[i], page.Items[j] = page.Items[j], page.Items[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+		// 从后一页返回的, 一定有下一页
+		page.HasNext = true
+	} else if q.Cursor != "" {
+		page.HasPrev = true
+	}
+	if len(keys) > 0 {
+		if page.HasNext {
+			page.NextCursor = encodePageCursor(pageCursor{Values: keys[len(keys)-1]})
+		}
+		if page.HasPrev {
+			page.PrevCursor = encodePageCursor(pageCursor{Backward: true, Values: keys[0]})
+		}
+	}
+	return page, nil
+}
+
+func offsetPageSQL(q PageQuery) (string, []interface{}) {
+	sqlCase := "SELECT * FROM (" + q.SQL + ") judb_page"
+	if q.OrderBy != "" {
+		sqlCase += " ORDER BY " + q.OrderBy
+	}
+	sqlCase += " LIMIT ? OFFSET ?"
+	args := append(append([]interface{}{}, q.Args...), q.Size+1, (q.Page-1)*q.Size)
+	return sqlCase, args
+}
+
+// keysetPageSQL 生成键集分页的语句. 排序方向可以不同, 所以不使用 (a, b) > (?, ?) 的形式,
+// 而是展开为 a > ? OR (a = ? AND b > ?). 向前翻页时比较和排序的方向都反过来, 结果再倒序.
+func keysetPageSQL(dbType string, q PageQuery, cursor *pageCursor) (string, []interface{}) {
+	args := append([]interface{}{}, q.Args...)
+	backward := cursor != nil && cursor.Backward
+	var where []string
+	if cursor != nil {
+		for i := range q.Keys {
+			var terms []string
+			for j := 0; j < i; j++ {
+				terms = append(terms, quoteIdent(dbType, q.Keys[j].Column)+" = ?")
+				args = append(args, cursor.Values[j])
+			}
+			op := ">"
+			if q.Keys[i].Desc != backward {
+				op = "<"
+			}
+			terms = append(terms, quoteIdent(dbType, q.Keys[i].Column)+" "+op+" ?")
+			args = append(args, cursor.Values[i])
+			where = append(where, "("+strings.Join(terms, " AND ")+")")
+		}
+	}
+	var order []string
+	for _, key := range q.Keys {
+		dir := " ASC"
+		if key.Desc != backward {
+			dir = " DESC"
+		}
+		order = append(order, quoteIdent(dbType, key.Column)+dir)
+	}
+	sqlCase := "SELECT * FROM (" + q.SQL + ") judb_page"
+	if len(where) > 0 {
+		sqlCase += " WHERE " + strings.Join(where, " OR ")
+	}
+	sqlCase += " ORDER BY " + strings.Join(order, ", ") + " LIMIT ?"
+	return sqlCase, append(args, q.Size+1)
+}
+
+func pageKeyIndex(rows *sql.Rows, keys []PageKey) ([]int, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	index := make([]int, len(keys))
+	for i, key := range keys {
+		index[i] = -1
+		for j, col := range columns {
+			if col == key.Column {
+				index[i] = j
+				break
+			}
+		}
+		if index[i] < 0 {
+			return nil, fmt.Errorf("分页的排序列 %s 不在查询结果中", key.Column)
+		}
+	}
+	return index, nil
+}
+
+// pageKeyValues 读取当前行排序列的值, sql.Rows 的同一行可以多次 Scan, 不影响调用者的 scan
+func pageKeyValues(rows *sql.Rows, index []int) ([]interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range dest {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	key := make([]interface{}, len(index))
+	for i, j := range index {
+		if b, ok := values[j].([]byte); ok {
+			key[i] = string(b)
+		} else {
+			key[i] = values[j]
+		}
+	}
+	return key, nil
+}
+
+type pageCursor struct {
+	Backward bool
+	Values   []interface{}
+}
+
+// cursorValue 游标中的值带有类型, 解码后整数和时间不会变成 float64 和字符串
+type cursorValue struct {
+	T string `json:"t"`
+	V string `json:"v,omitempty"`
+}
+
+type cursorData struct {
+	B bool          `json:"b,omitempty"`
+	K []cursorValue `json:"k"`
+}
+
+func encodePageCursor(c pageCursor) string {
+	data := cursorData{B: c.Backward}
+	for _, v := range c.Values {
+		var cv cursorValue
+		switch x := v.(type) {
+		case nil:
+			cv.T = "n"
+		case int64:
+			cv = cursorValue{T: "i", V: strconv.FormatInt(x, 10)}
+		case float64:
+			cv = cursorValue{T: "f", V: strconv.FormatFloat(x, 'g', -1, 64)}
+		case bool:
+			cv = cursorValue{T: "b", V: strconv.FormatBool(x)}
+		case time.Time:
+			cv = cursorValue{T: "t", V: x.Format(time.RFC3339Nano)}
+		default:
+			cv = cursorValue{T: "s", V: fmt.Sprint(x)}
+		}
+		data.K = append(data.K, cv)
+	}
+	b, _ := json.Marshal(data)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageCursor(s string, keyCount int) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("分页游标无效: %w", err)
+	}
+	var data cursorData
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("分页游标无效: %w", err)
+	}
+	if len(data.K) != keyCount {
+		return nil, fmt.Errorf("分页游标无效: 有 %d 个值, 排序列有 %d 个", len(data.K), keyCount)
+	}
+	c := &pageCursor{Backward: data.B}
+	for _, cv := range data.K {
+		var v interface{}
+		switch cv.T {
+		case "n":
+		case "i":
+			v, err = strconv.ParseInt(cv.V, 10, 64)
+		case "f":
+			v, err = strconv.ParseFloat(cv.V, 64)
+		case "b":
+			v, err = strconv.ParseBool(cv.V)
+		case "t":
+			v, err = time.Parse(time.RFC3339Nano, cv.V)
+		case "s":
+			v = cv.V
+		default:
+			err = fmt.Errorf("未知的类型 %q", cv.T)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("分页游标无效: %w", err)
+		}
+		c.Values = append(c.Values, v)
+	}
+	return c, nil
+}
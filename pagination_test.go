@@ -0,0 +1,140 @@
+package judb
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openPageDb(t *testing.T) *Db {
+	t.Helper()
+	db := &Db{}
+	if !db.OpenSqlite3(filepath.Join(t.TempDir(), "page.db"), "") {
+		t.Fatal("open sqlite failed")
+	}
+	t.Cleanup(db.Close)
+	db.SetErrorReporter(SilentReporter{})
+	statements := []string{
+		"CREATE TABLE scores (id INTEGER PRIMARY KEY, team TEXT, score INTEGER)",
+		// 分数有重复, 需要 id 作为第二个排序列
+		"INSERT INTO scores (id, team, score) VALUES (1, 'a', 50), (2, 'a', 70), (3, 'b', 70), (4, 'a', 30), " +
+			"(5, 'a', 70), (6, 'a', 90), (7, 'a', 50), (8, 'a', 10)",
+	}
+	for _, s := range statements {
+		if mr := db.Exec(s); mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+	}
+	return db
+}
+
+func scanScoreId(rows *sql.Rows) (int64, error) {
+	var id, score int64
+	var team string
+	err := rows.Scan(&id, &team, &score)
+	return id, err
+}
+
+func TestKeysetPagination(t *testing.T) {
+	db := openPageDb(t)
+	q := PageQuery{
+		SQL:       "SELECT id, team, score FROM scores WHERE team = ?",
+		Args:      []interface{}{"a"},
+		Size:      3,
+		WithTotal: true,
+		Keys:      []PageKey{{Column: "score", Desc: true}, {Column: "id"}},
+	}
+	// team a 按 score DESC, id ASC 排序是 6, 2, 5, 1, 7, 4, 8
+	wantPages := [][]int64{{6, 2, 5}, {1, 7, 4}, {8}}
+
+	var pages []*Page[int64]
+	for i, want := range wantPages {
+		page, mr := Paginate(db, q, scanScoreId)
+		if mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+		if !reflect.DeepEqual(page.Items, want) {
+			t.Fatalf("page %d = %v, want %v", i+1, page.Items, want)
+		}
+		last := i == len(wantPages)-1
+		if page.HasNext == last || (page.NextCursor == "") != last || page.HasPrev != (i > 0) || (page.PrevCursor != "") != (i > 0) {
+			t.Fatalf("page %d info = %+v", i+1, page.PageInfo)
+		}
+		if page.Total != 7 || page.Size != 3 || page.Page != 0 {
+			t.Fatalf("page %d info = %+v", i+1, page.PageInfo)
+		}
+		pages = append(pages, page)
+		q.Cursor = page.NextCursor
+	}
+
+	// 从最后一页向前翻, 得到的页和向后翻时相同
+	q.Cursor = pages[2].PrevCursor
+	for i := 1; i >= 0; i-- {
+		page, mr := Paginate(db, q, scanScoreId)
+		if mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+		if !reflect.DeepEqual(page.Items, wantPages[i]) {
+			t.Fatalf("backward page %d = %v, want %v", i+1, page.Items, wantPages[i])
+		}
+		if !page.HasNext || page.NextCursor == "" || page.HasPrev != (i > 0) || (page.PrevCursor != "") != (i > 0) {
+			t.Fatalf("backward page %d info = %+v", i+1, page.PageInfo)
+		}
+		// 向前翻得到的下一页游标和向后翻时一样能用
+		next, mr := Paginate(db, PageQuery{SQL: q.SQL, Args: q.Args, Size: q.Size, Keys: q.Keys, Cursor: page.NextCursor}, scanScoreId)
+		if mr.Fail() || !reflect.DeepEqual(next.Items, wantPages[i+1]) {
+			t.Fatalf("next of backward page %d = %v, %s", i+1, next.Items, mr.Error)
+		}
+		q.Cursor = page.PrevCursor
+	}
+}
+
+func TestKeysetPaginationInvalidCursor(t *testing.T) {
+	db := openPageDb(t)
+	q := PageQuery{SQL: "SELECT id, team, score FROM scores", Keys: []PageKey{{Column: "id"}}}
+	for _, cursor := range []string{
+		"not base64!",
+		encodePageCursor(pageCursor{Values: []interface{}{int64(1), int64(2)}}),
+	} {
+		q.Cursor = cursor
+		if _, mr := Paginate(db, q, scanScoreId); !mr.Fail() {
+			t.Errorf("cursor %q accepted", cursor)
+		}
+	}
+	q.Cursor = ""
+	q.Keys = []PageKey{{Column: "missing"}}
+	if _, mr := Paginate(db, q, scanScoreId); !mr.Fail() {
+		t.Error("missing key column accepted")
+	}
+}
+
+func TestPageCursorTypes(t *testing.T) {
+	when := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	values := []interface{}{nil, int64(-42), 1.5, true, when, "a,b"}
+	c, err := decodePageCursor(encodePageCursor(pageCursor{Backward: true, Values: values}), len(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Backward || !reflect.DeepEqual(c.Values, values) {
+		t.Fatalf("cursor = %+v, want %v", c, values)
+	}
+}
+
+func TestOffsetPagination(t *testing.T) {
+	db := openPageDb(t)
+	q := PageQuery{SQL: "SELECT id, team, score FROM scores", Size: 3, Page: 2, OrderBy: "score DESC, id", WithTotal: true}
+	page, mr := Paginate(db, q, scanScoreId)
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	// 按 score DESC, id 排序是 6, 2, 3, 5, 1, 7, 4, 8
+	if !reflect.DeepEqual(page.Items, []int64{5, 1, 7}) || !page.HasNext || !page.HasPrev || page.Total != 8 || page.Page != 2 {
+		t.Fatalf("page = %+v", page)
+	}
+	q.Page = 3
+	if page, mr = Paginate(db, q, scanScoreId); mr.Fail() || !reflect.DeepEqual(page.Items, []int64{4, 8}) || page.HasNext {
+		t.Fatalf("last page = %+v, %s", page, mr.Error)
+	}
+}
@@ -1,8 +1,11 @@
 package postgres
 
 import (
+	"crypto/tls"
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -22,28 +25,68 @@ type Config struct {
 	CaCertPath     string
 	ReadOnly       bool
 	ConnParam      map[string]string //额外的连接参数
+	// TLSConfig 如果设置, 连接时直接使用这个 TLS 配置, 而不是从证书路径加载, 例如 judb.CertReloader 提供的热更新配置
+	TLSConfig *tls.Config
 }
 
+// connParamKey ConnParam 中参数名称的格式, 名称不转义, 其它字符可能改变 DSN 的含义
+var connParamKey = regexp.MustCompile(`^[a-z_]+$`)
+
+// sensitiveKeys String 中需要隐藏的参数
+var sensitiveKeys = map[string]bool{
+	"password":    true,
+	"sslpassword": true,
+}
+
+// Validate 检查 ConnParam 的参数名称, 名称只能包含小写字母和下划线
+func (cfg *Config) Validate() error {
+	for key := range cfg.ConnParam {
+		if !connParamKey.MatchString(key) {
+			return fmt.Errorf("无效的连接参数名称: %q", key)
+		}
+	}
+	return nil
+}
+
+// FormatDSN 生成 libpq 格式的 DSN, 所有的值都经过 EscapeDSNValue 转义, 额外参数按名称排序, 所以相同的配置总是生成相同的字符串.
+// ConnParam 中有无效的参数名称时输出错误并返回空串, 错误可以通过 Validate 得到
 func (cfg *Config) FormatDSN() string {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
-		cfg.Host,
-		cfg.Port,
-		cfg.User,
-		EscapeDSNValue(cfg.Password),
-		cfg.Database,
-	)
-	if cfg.CaCertPath != "" {
+	if ju.OutputErrorTrace(cfg.Validate(), 1) {
+		return ""
+	}
+	return cfg.formatDSN(false)
+}
+
+// String 返回隐藏了密码的 DSN, 可以安全的输出到日志, ConnParam 中的 password 和 sslpassword 同样被隐藏.
+// ConnParam 中无效的参数名称不会输出
+func (cfg *Config) String() string {
+	return cfg.formatDSN(true)
+}
+
+func (cfg *Config) formatDSN(redact bool) string {
+	pairs := [][2]string{
+		{"host", cfg.Host},
+		{"port", strconv.Itoa(int(cfg.Port))},
+		{"user", cfg.User},
+		{"password", cfg.Password},
+		{"dbname", cfg.Database},
+	}
+	if cfg.TLSConfig != nil {
+		// TLS 配置在连接时替换, 这里只需要要求使用 SSL
+		pairs = append(pairs, [2]string{"sslmode", "require"})
+	} else if cfg.CaCertPath != "" {
 		// 如果提供了证书，则使用最安全的 verify-full 模式
 		// 并将所有证书路径附加到 DSN 中。
 		// 使用 filepath.ToSlash 确保路径分隔符的跨平台兼容性。
-		dsn += fmt.Sprintf(" sslmode=verify-full sslrootcert=%s sslcert=%s sslkey=%s",
-			filepath.ToSlash(cfg.CaCertPath),
-			filepath.ToSlash(cfg.ClientCertPath),
-			filepath.ToSlash(cfg.ClientKeyPath),
+		pairs = append(pairs,
+			[2]string{"sslmode", "verify-full"},
+			[2]string{"sslrootcert", filepath.ToSlash(cfg.CaCertPath)},
+			[2]string{"sslcert", filepath.ToSlash(cfg.ClientCertPath)},
+			[2]string{"sslkey", filepath.ToSlash(cfg.ClientKeyPath)},
 		)
 	} else {
 		// 如果没有提供证书，则明确禁用 SSL
-		dsn += " sslmode=disable"
+		pairs = append(pairs, [2]string{"sslmode", "disable"})
 	}
 
 	// 附加时区参数，这是一个非常好的实践
@@ -51,21 +94,41 @@ func (cfg *Config) FormatDSN() string {
 		// 如果未指定，默认为 UTC
 		cfg.TimeZone = "UTC"
 	}
-	dsn += fmt.Sprintf(" timezone=%s", cfg.TimeZone)
+	pairs = append(pairs, [2]string{"timezone", cfg.TimeZone})
 	if cfg.ClientEncode == "" {
 		cfg.ClientEncode = "utf8"
 	}
-	dsn += fmt.Sprintf(" client_encoding=%s", cfg.ClientEncode)
+	pairs = append(pairs, [2]string{"client_encoding", cfg.ClientEncode})
 
 	if cfg.ReadOnly {
-		dsn += " default_transaction_read_only=true"
+		pairs = append(pairs, [2]string{"default_transaction_read_only", "true"})
 	}
 
-	for key, val := range cfg.ConnParam {
-		dsn += fmt.Sprintf(" %s=%s", key, val)
+	keys := make([]string, 0, len(cfg.ConnParam))
+	for key := range cfg.ConnParam {
+		if connParamKey.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pairs = append(pairs, [2]string{key, cfg.ConnParam[key]})
 	}
 
-	return dsn
+	var builder strings.Builder
+	for i, pair := range pairs {
+		if i > 0 {
+			builder.WriteByte(' ')
+		}
+		value := pair[1]
+		if redact && sensitiveKeys[pair[0]] && value != "" {
+			value = "******"
+		}
+		builder.WriteString(pair[0])
+		builder.WriteByte('=')
+		builder.WriteString(EscapeDSNValue(value))
+	}
+	return builder.String()
 }
 func (cfg *Config) MakeConfig(host, port, database, user, password string) bool {
 	cfg.Host = host
@@ -101,16 +164,16 @@ func (cfg *Config) MakeSSLConfig(host, port, database, user, password, clientKey
 	return true
 }
 
-// EscapeDSNValue 对 DSN 中的值进行安全转义。
-// 如果值包含空格，它会用单引号包裹，并转义内部的单引号和反斜杠。
+// EscapeDSNValue 按照 libpq 的规则对 DSN 中的值进行转义。
+// 如果值是空串, 或者包含空白字符、单引号、反斜杠或等号, 它会用单引号包裹, 并用反斜杠转义内部的单引号和反斜杠。
 func EscapeDSNValue(value string) string {
 	// 检查是否需要用单引号包裹
-	if strings.Contains(value, " ") {
-		// 替换反斜杠为 \\
-		value = strings.ReplaceAll(value, `\`, `\\`)
-		// 替换单引号为 ''
-		value = strings.ReplaceAll(value, `'`, `''`)
-		return `'` + value + `'`
-	}
-	return value
+	if value != "" && !strings.ContainsAny(value, " \t\n\r\v\f'\\=") {
+		return value
+	}
+	// 替换反斜杠为 \\
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	// 替换单引号为 \'
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return `'` + value + `'`
 }
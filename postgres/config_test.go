@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscapeDSNValue(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"plain", "plain"},
+		{"", "''"},
+		{"two words", "'two words'"},
+		{"tab\there", "'tab\there'"},
+		{"it's", `'it\'s'`},
+		{`back\slash`, `'back\\slash'`},
+		{"a=b", "'a=b'"},
+		{`x' password='y`, `'x\' password=\'y'`},
+	}
+	for _, tt := range tests {
+		if got := EscapeDSNValue(tt.value); got != tt.want {
+			t.Errorf("EscapeDSNValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDSN(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		dsn    string
+		redact string
+	}{
+		{
+			name:   "quoted values",
+			cfg:    Config{Host: "localhost", Port: 5432, User: "app user", Password: `p'w\d`, Database: ""},
+			dsn:    `host=localhost port=5432 user='app user' password='p\'w\\d' dbname='' sslmode=disable timezone=UTC client_encoding=utf8`,
+			redact: `host=localhost port=5432 user='app user' password=****** dbname='' sslmode=disable timezone=UTC client_encoding=utf8`,
+		},
+		{
+			name:   "empty password is not masked",
+			cfg:    Config{Host: "db", Port: 5432, User: "u", Database: "d", ReadOnly: true},
+			dsn:    `host=db port=5432 user=u password='' dbname=d sslmode=disable timezone=UTC client_encoding=utf8 default_transaction_read_only=true`,
+			redact: `host=db port=5432 user=u password='' dbname=d sslmode=disable timezone=UTC client_encoding=utf8 default_transaction_read_only=true`,
+		},
+		{
+			name: "sorted extra params with secrets",
+			cfg: Config{Host: "db", Port: 5432, User: "u", Password: "pw", Database: "d",
+				ConnParam: map[string]string{"sslpassword": "key pass", "application_name": "my app", "connect_timeout": "5"}},
+			dsn: `host=db port=5432 user=u password=pw dbname=d sslmode=disable timezone=UTC client_encoding=utf8 ` +
+				`application_name='my app' connect_timeout=5 sslpassword='key pass'`,
+			redact: `host=db port=5432 user=u password=****** dbname=d sslmode=disable timezone=UTC client_encoding=utf8 ` +
+				`application_name='my app' connect_timeout=5 sslpassword=******`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if dsn := tt.cfg.FormatDSN(); dsn != tt.dsn {
+				t.Errorf("FormatDSN =\n%s\nwant\n%s", dsn, tt.dsn)
+			}
+			if s := tt.cfg.String(); s != tt.redact {
+				t.Errorf("String =\n%s\nwant\n%s", s, tt.redact)
+			}
+		})
+	}
+}
+
+func TestInvalidConnParam(t *testing.T) {
+	for _, key := range []string{"", "Host", "a b", "sslmode=disable x", "x-y"} {
+		cfg := Config{Host: "db", Port: 5432, User: "u", Database: "d", ConnParam: map[string]string{key: "v"}}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate accepted %q", key)
+		}
+		if dsn := cfg.FormatDSN(); dsn != "" {
+			t.Errorf("FormatDSN with key %q = %s, want empty", key, dsn)
+		}
+		if s := cfg.String(); strings.Contains(s, key+"=") && key != "" {
+			t.Errorf("String with key %q = %s", key, s)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package judb
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/jsuserapp/ju"
+)
+
+// ErrorReporter 错误输出接口, Db 和日志系统的错误都通过它输出, 默认的实现和之前一样输出到控制台.
+//
+// skip 是调用栈的层次, 和 ju.LogErrorTrace 相同, 0 是调用 Report 的位置, 1 是上一级调用位置.
+type ErrorReporter interface {
+	Report(skip int, err error)
+}
+
+// SilentReporter 不输出任何错误, 错误仍然通过 SqlResult 和返回值返回给调用者
+type SilentReporter struct{}
+
+func (SilentReporter) Report(int, error) {}
+
+// JuReporter 使用 ju.LogErrorTrace 输出错误, 这是 Db 的默认设置, 错误会打印到控制台, 并且保存到 ju 的日志数据库
+type JuReporter struct{}
+
+func (JuReporter) Report(skip int, err error) {
+	ju.LogErrorTrace(err, skip+1)
+}
+
+// JuConsoleReporter 使用 ju.OutputColor 输出错误, 只打印到控制台, 不会保存到数据库.
+// 这是日志系统的默认设置, 避免保存日志失败时循环调用.
+type JuConsoleReporter struct{}
+
+func (JuConsoleReporter) Report(skip int, err error) {
+	ju.OutputColor(skip+1, ju.ColorRed, err.Error())
+}
+
+// SlogReporter 通过 log/slog 输出错误, Logger 为 nil 时使用 slog.Default(), 调用位置记录在 trace 属性中
+type SlogReporter struct {
+	Logger *slog.Logger
+	Level  slog.Level // 默认是 slog.LevelInfo, 通常应该设置为 slog.LevelError
+}
+
+func (r SlogReporter) Report(skip int, err error) {
+	logger := r.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Log(context.Background(), r.Level, err.Error(), "trace", ju.GetTrace(skip+2))
+}
+
+// SetErrorReporter 设置 Db 的错误输出方式, 为 nil 时恢复默认的 JuReporter. 需要在使用 Db 之前调用.
+func (db *Db) SetErrorReporter(reporter ErrorReporter) {
+	db.reporter = reporter
+}
+
+// reportError err 不是 nil 时输出错误并返回 true, skip 的含义和 ErrorReporter 相同, 0 是调用 reportError 的位置
+func (db *Db) reportError(skip int, err error) bool {
+	if err == nil {
+		return false
+	}
+	reporter := db.reporter
+	if reporter == nil {
+		reporter = JuReporter{}
+	}
+	reporter.Report(skip+1, err)
+	return true
+}
+
+// SetLogErrorReporter 设置日志系统自身的错误输出方式, 例如日志表创建失败, 为 nil 时恢复默认的 JuConsoleReporter.
+// 和 SetLogDb 一样, 需要在使用日志函数之前调用.
+func SetLogErrorReporter(reporter ErrorReporter) {
+	logParam.Reporter = reporter
+}
+
+// logError 输出日志系统的错误, 位置是调用 logError 的地方
+func logError(err error) {
+	if err == nil {
+		return
+	}
+	reporter := logParam.Reporter
+	if reporter == nil {
+		reporter = JuConsoleReporter{}
+	}
+	reporter.Report(1, err)
+}
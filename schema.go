@@ -0,0 +1,473 @@
+package judb
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Column 表的列
+type Column struct {
+	Name          string
+	Type          string  // 数据库中的类型定义, 例如 varchar(255), INTEGER, character varying(64)
+	Nullable      bool    // 是否允许 NULL
+	Default       *string // 默认值表达式, nil 表示没有默认值
+	PrimaryKey    bool    // 是否是主键的一部分
+	AutoIncrement bool    // 是否自动增长, 包括 MySQL 的 AUTO_INCREMENT, PostgreSQL 的 serial 和 identity, SQLite 的 INTEGER PRIMARY KEY
+}
+
+// Index 表的索引, 表达式索引的列在 MySQL 和 PostgreSQL 中是表达式的文本, 在 SQLite 中是空串
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+	Primary bool // 是否是主键索引, SQLite 的 INTEGER PRIMARY KEY 没有索引
+	// Constraint 是否是 UNIQUE 约束创建的索引, 这样的索引在 PostgreSQL 中需要通过 DROP CONSTRAINT 删除,
+	// 在 SQLite 中不能单独删除. MySQL 的 UNIQUE 约束就是普通的唯一索引, 这个字段总是 false
+	Constraint bool
+}
+
+// ForeignKey 外键, SQLite 的外键没有名称
+type ForeignKey struct {
+	Name       string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnUpdate   string // NO ACTION, RESTRICT, CASCADE, SET NULL 或 SET DEFAULT
+	OnDelete   string
+}
+
+// TableSchema 一个表的完整结构
+type TableSchema struct {
+	Name        string
+	Columns     []Column
+	PrimaryKey  []string
+	Indexes     []Index
+	ForeignKeys []ForeignKey
+}
+
+// Schema 数据库的结构, 可以由 Db.Schema 读取, 也可以手工声明
+type Schema struct {
+	Dialect string // 读取时的数据库类型, 手工声明时可以为空
+	Tables  []TableSchema
+}
+
+// Table 按名称查找表, 找不到时返回 nil
+func (s *Schema) Table(name string) *TableSchema {
+	for i := range s.Tables {
+		if s.Tables[i].Name == name {
+			return &s.Tables[i]
+		}
+	}
+	return nil
+}
+
+// Column 按名称查找列, 找不到时返回 nil
+func (t *TableSchema) Column(name string) *Column {
+	for i := range t.Columns {
+		if t.Columns[i].Name == name {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// Tables 返回当前数据库 (MySQL 的 DATABASE(), PostgreSQL 的 current_schema()) 中的所有表名, 不包含视图, 按名称排序
+func (db *Db) Tables() ([]string, SqlResult) {
+	var sqlCase string
+	switch db.dbType {
+	case DatabaseTypeMysql:
+		sqlCase = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name"
+	case DatabaseTypePostgres:
+		sqlCase = "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name"
+	default:
+		sqlCase = "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name"
+	}
+	var tables []string
+	err := db.collect(sqlCase, nil, func(rows *sql.Rows) error {
+		var name string
+		err := rows.Scan(&name)
+		tables = append(tables, name)
+		return err
+	})
+	if db.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return tables, SqlResult{}
+}
+
+// Columns 返回表的所有列, 按定义的顺序排列
+func (db *Db) Columns(table string) ([]Column, SqlResult) {
+	columns, err := db.columns(table)
+	if db.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return columns, SqlResult{}
+}
+
+// Indexes 返回表的所有索引, 包括主键索引, 按名称排序
+func (db *Db) Indexes(table string) ([]Index, SqlResult) {
+	indexes, err := db.indexes(table)
+	if db.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return indexes, SqlResult{}
+}
+
+// PrimaryKey 返回主键的列, 按主键中的顺序排列, 没有主键时返回空
+func (db *Db) PrimaryKey(table string) ([]string, SqlResult) {
+	pk, err := db.primaryKey(table)
+	if db.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return pk, SqlResult{}
+}
+
+// ForeignKeys 返回表的所有外键
+func (db *Db) ForeignKeys(table string) ([]ForeignKey, SqlResult) {
+	fks, err := db.foreignKeys(table)
+	if db.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return fks, SqlResult{}
+}
+
+// Table 返回一个表的完整结构
+func (db *Db) Table(table string) (*TableSchema, SqlResult) {
+	ts, err := db.tableSchema(table)
+	if db.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return ts, SqlResult{}
+}
+
+// Schema 返回所有表的结构
+func (db *Db) Schema() (*Schema, SqlResult) {
+	tables, mr := db.Tables()
+	if mr.Fail() {
+		return nil, mr
+	}
+	schema := &Schema{Dialect: db.dbType}
+	for _, table := range tables {
+		ts, err := db.tableSchema(table)
+		if db.reportError(errSkip, err) {
+			return nil, NewSqlResult(err)
+		}
+		schema.Tables = append(schema.Tables, *ts)
+	}
+	return schema, SqlResult{}
+}
+
+func (db *Db) tableSchema(table string) (*TableSchema, error) {
+	ts := &TableSchema{Name: table}
+	var err error
+	if ts.Columns, err = db.columns(table); err != nil {
+		return nil, err
+	}
+	if len(ts.Columns) == 0 {
+		return nil, fmt.Errorf("表 %s 不存在", table)
+	}
+	if ts.PrimaryKey, err = db.primaryKey(table); err != nil {
+		return nil, err
+	}
+	if ts.Indexes, err = db.indexes(table); err != nil {
+		return nil, err
+	}
+	if ts.ForeignKeys, err = db.foreignKeys(table); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// collect 执行查询并对每一行调用 scan, 不调用 Hook, 也不输出错误
+func (db *Db) collect(sqlCase string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	if db.db == nil {
+		return fmt.Errorf("数据库对象不可用 nil")
+	}
+	rows, err := db.reader().Query(sqlCase, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Db) columns(table string) ([]Column, error) {
+	var columns []Column
+	var err error
+	switch db.dbType {
+	case DatabaseTypeMysql:
+		sqlCase := `SELECT column_name, column_type, is_nullable, column_default, column_key, extra
+			FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position`
+		err = db.collect(sqlCase, []interface{}{table}, func(rows *sql.Rows) error {
+			var col Column
+			var nullable, key, extra string
+			var def sql.NullString
+			if err := rows.Scan(&col.Name, &col.Type, &nullable, &def, &key, &extra); err != nil {
+				return err
+			}
+			col.Nullable = nullable == "YES"
+			if def.Valid && def.String != "NULL" {
+				value := mysqlDefaultExpr(def.String, extra)
+				col.Default = &value
+			}
+			col.PrimaryKey = key == "PRI"
+			col.AutoIncrement = strings.Contains(strings.ToLower(extra), "auto_increment")
+			columns = append(columns, col)
+			return nil
+		})
+	case DatabaseTypePostgres:
+		sqlCase := `SELECT a.attname, format_type(a.atttypid, a.atttypmod), NOT a.attnotnull, pg_get_expr(d.adbin, d.adrelid),
+				COALESCE(a.attnum = ANY(p.conkey), false), a.attidentity <> ''
+			FROM pg_attribute a
+			LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+			LEFT JOIN pg_constraint p ON p.conrelid = a.attrelid AND p.contype = 'p'
+			WHERE a.attrelid = to_regclass(quote_ident($1)) AND a.attnum > 0 AND NOT a.attisdropped
+			ORDER BY a.attnum`
+		err = db.collect(sqlCase, []interface{}{table}, func(rows *sql.Rows) error {
+			var col Column
+			var def sql.NullString
+			if err := rows.Scan(&col.Name, &col.Type, &col.Nullable, &def, &col.PrimaryKey, &col.AutoIncrement); err != nil {
+				return err
+			}
+			if def.Valid {
+				col.Default = &def.String
+				if strings.HasPrefix(def.String, "nextval(") {
+					col.AutoIncrement = true
+				}
+			}
+			columns = append(columns, col)
+			return nil
+		})
+	default:
+		// hidden 为 1 的是虚拟表的隐藏列, 2 和 3 是生成列
+		sqlCase := `SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_xinfo(?) WHERE hidden <> 1 ORDER BY cid`
+		pkCount := 0
+		err = db.collect(sqlCase, []interface{}{table}, func(rows *sql.Rows) error {
+			var col Column
+			var notNull, pk int
+			var def sql.NullString
+			if err := rows.Scan(&col.Name, &col.Type, &notNull, &def, &pk); err != nil {
+				return err
+			}
+			col.Nullable = notNull == 0 && pk == 0
+			if def.Valid {
+				col.Default = &def.String
+			}
+			col.PrimaryKey = pk > 0
+			if col.PrimaryKey {
+				pkCount++
+			}
+			columns = append(columns, col)
+			return nil
+		})
+		// 只有一列的 INTEGER PRIMARY KEY 是 rowid 的别名, 会自动增长
+		if err == nil && pkCount == 1 {
+			for i := range columns {
+				if columns[i].PrimaryKey && strings.EqualFold(columns[i].Type, "INTEGER") {
+					columns[i].AutoIncrement = true
+				}
+			}
+		}
+	}
+	return columns, err
+}
+
+func (db *Db) indexes(table string) ([]Index, error) {
+	var indexes []Index
+	add := func(name, column string, unique, primary bool) {
+		indexes = appendIndexColumn(indexes, name, column, unique, primary)
+	}
+	switch db.dbType {
+	case DatabaseTypeMysql:
+		sqlCase := `SELECT index_name, non_unique, COALESCE(column_name, expression) FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = ? ORDER BY index_name, seq_in_index`
+		err := db.collect(sqlCase, []interface{}{table}, func(rows *sql.Rows) error {
+			var name, column string
+			var nonUnique int
+			if err := rows.Scan(&name, &nonUnique, &column); err != nil {
+				return err
+			}
+			add(name, column, nonUnique == 0, name == "PRIMARY")
+			return nil
+		})
+		if err != nil && strings.Contains(err.Error(), "expression") {
+			// MySQL 8.0.13 之前没有 expression 列
+			sqlCase = strings.Replace(sqlCase, "COALESCE(column_name, expression)", "column_name", 1)
+			indexes = nil
+			err = db.collect(sqlCase, []interface{}{table}, func(rows *sql.Rows) error {
+				var name, column string
+				var nonUnique int
+				if err := rows.Scan(&name, &nonUnique, &column); err != nil {
+					return err
+				}
+				add(name, column, nonUnique == 0, name == "PRIMARY")
+				return nil
+			})
+		}
+		return indexes, err
+	case DatabaseTypePostgres:
+		err := db.collect(postgresIndexQuery, []interface{}{table}, func(rows *sql.Rows) error {
+			var name, column string
+			var unique, primary, constraint bool
+			if err := rows.Scan(&name, &unique, &primary, &constraint, &column); err != nil {
+				return err
+			}
+			add(name, column, unique, primary)
+			indexes[len(indexes)-1].Constraint = constraint
+			return nil
+		})
+		return indexes, err
+	}
+
+	type indexInfo struct {
+		name   string
+		unique bool
+		origin string
+	}
+	var list []indexInfo
+	err := db.collect(`SELECT name, "unique", origin FROM pragma_index_list(?) ORDER BY name`, []interface{}{table}, func(rows *sql.Rows) error {
+		var info indexInfo
+		if err := rows.Scan(&info.name, &info.unique, &info.origin); err != nil {
+			return err
+		}
+		list = append(list, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range list {
+		index := Index{Name: info.name, Unique: info.unique, Primary: info.origin == "pk", Constraint: info.origin == "u"}
+		err = db.collect(`SELECT name FROM pragma_index_info(?) ORDER BY seqno`, []interface{}{info.name}, func(rows *sql.Rows) error {
+			var column sql.NullString
+			if err := rows.Scan(&column); err != nil {
+				return err
+			}
+			// 表达式索引的列没有名称
+			index.Columns = append(index.Columns, column.String)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// postgresIndexQuery 按顺序读取 PostgreSQL 索引的键列. int2vector 的下标从 0 开始, pg_get_indexdef 的列号从 1 开始,
+// 所以使用 WITH ORDINALITY 得到从 1 开始的序号, 序号为 0 时 pg_get_indexdef 返回整个 CREATE INDEX 语句.
+// INCLUDE 的列排在键列后面, 不属于索引的键. UNIQUE 约束的索引在 pg_constraint 中有对应的行, 名称和约束相同
+const postgresIndexQuery = `SELECT i.relname, ix.indisunique, ix.indisprimary,
+		EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = ix.indexrelid AND c.contype = 'u'),
+		pg_get_indexdef(ix.indexrelid, k.ord::int, true)
+	FROM pg_index ix
+	JOIN pg_class i ON i.oid = ix.indexrelid
+	CROSS JOIN LATERAL unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+	WHERE ix.indrelid = to_regclass(quote_ident($1)) AND k.ord <= ix.indnkeyatts
+	ORDER BY i.relname, k.ord`
+
+// appendIndexColumn 把按索引名称和列顺序排列的一行加入 indexes, 名称和上一行相同时是同一个索引的下一列
+func appendIndexColumn(indexes []Index, name, column string, unique, primary bool) []Index {
+	if n := len(indexes); n > 0 && indexes[n-1].Name == name {
+		indexes[n-1].Columns = append(indexes[n-1].Columns, column)
+		return indexes
+	}
+	return append(indexes, Index{Name: name, Columns: []string{column}, Unique: unique, Primary: primary})
+}
+
+func (db *Db) primaryKey(table string) ([]string, error) {
+	var pk []string
+	var sqlCase string
+	switch db.dbType {
+	case DatabaseTypeMysql:
+		sqlCase = `SELECT column_name FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = ? AND index_name = 'PRIMARY' ORDER BY seq_in_index`
+	case DatabaseTypePostgres:
+		sqlCase = `SELECT a.attname FROM pg_constraint c
+			CROSS JOIN LATERAL unnest(c.conkey) WITH ORDINALITY AS k(attnum, ord)
+			JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+			WHERE c.conrelid = to_regclass(quote_ident($1)) AND c.contype = 'p' ORDER BY k.ord`
+	default:
+		sqlCase = `SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk`
+	}
+	err := db.collect(sqlCase, []interface{}{table}, func(rows *sql.Rows) error {
+		var name string
+		err := rows.Scan(&name)
+		pk = append(pk, name)
+		return err
+	})
+	return pk, err
+}
+
+func (db *Db) foreignKeys(table string) ([]ForeignKey, error) {
+	var fks []ForeignKey
+	add := func(name, column, refTable, refColumn, onUpdate, onDelete string, first bool) {
+		if n := len(fks); n > 0 && !first {
+			fks[n-1].Columns = append(fks[n-1].Columns, column)
+			fks[n-1].RefColumns = append(fks[n-1].RefColumns, refColumn)
+			return
+		}
+		fks = append(fks, ForeignKey{Name: name, Columns: []string{column}, RefTable: refTable, RefColumns: []string{refColumn},
+			OnUpdate: onUpdate, OnDelete: onDelete})
+	}
+	var sqlCase string
+	switch db.dbType {
+	case DatabaseTypeMysql:
+		sqlCase = `SELECT k.constraint_name, k.column_name, k.referenced_table_name, k.referenced_column_name, r.update_rule, r.delete_rule, k.ordinal_position
+			FROM information_schema.key_column_usage k
+			JOIN information_schema.referential_constraints r
+				ON r.constraint_schema = k.constraint_schema AND r.constraint_name = k.constraint_name AND r.table_name = k.table_name
+			WHERE k.table_schema = DATABASE() AND k.table_name = ? AND k.referenced_table_name IS NOT NULL
+			ORDER BY k.constraint_name, k.ordinal_position`
+	case DatabaseTypePostgres:
+		sqlCase = `SELECT c.conname, a.attname, rt.relname, ra.attname,
+				CASE c.confupdtype WHEN 'r' THEN 'RESTRICT' WHEN 'c' THEN 'CASCADE' WHEN 'n' THEN 'SET NULL' WHEN 'd' THEN 'SET DEFAULT' ELSE 'NO ACTION' END,
+				CASE c.confdeltype WHEN 'r' THEN 'RESTRICT' WHEN 'c' THEN 'CASCADE' WHEN 'n' THEN 'SET NULL' WHEN 'd' THEN 'SET DEFAULT' ELSE 'NO ACTION' END,
+				k.ord
+			FROM pg_constraint c
+			CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(col, refcol, ord)
+			JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.col
+			JOIN pg_class rt ON rt.oid = c.confrelid
+			JOIN pg_attribute ra ON ra.attrelid = c.confrelid AND ra.attnum = k.refcol
+			WHERE c.contype = 'f' AND c.conrelid = to_regclass(quote_ident($1))
+			ORDER BY c.conname, k.ord`
+	default:
+		sqlCase = `SELECT '', "from", "table", COALESCE("to", ''), on_update, on_delete, seq + 1 FROM pragma_foreign_key_list(?) ORDER BY id, seq`
+	}
+	err := db.collect(sqlCase, []interface{}{table}, func(rows *sql.Rows) error {
+		var name, column, refTable, refColumn, onUpdate, onDelete string
+		var ord int
+		if err := rows.Scan(&name, &column, &refTable, &refColumn, &onUpdate, &onDelete, &ord); err != nil {
+			return err
+		}
+		add(name, column, refTable, refColumn, strings.ToUpper(onUpdate), strings.ToUpper(onDelete), ord == 1)
+		return nil
+	})
+	return fks, err
+}
+
+// mysqlDefaultExpr 把 information_schema 中的默认值转换为表达式. MySQL 保存的字符串默认值没有引号,
+// 表达式默认值的 extra 中有 DEFAULT_GENERATED, MariaDB 保存的字符串默认值已经带有引号.
+func mysqlDefaultExpr(value, extra string) string {
+	if strings.Contains(extra, "DEFAULT_GENERATED") || strings.HasPrefix(value, "'") {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	upper := strings.ToUpper(value)
+	if strings.HasPrefix(upper, "CURRENT_TIMESTAMP") || strings.HasPrefix(upper, "NOW(") {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
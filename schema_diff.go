@@ -0,0 +1,665 @@
+package judb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// SchemaChangeKind 结构变更的类型
+type SchemaChangeKind string
+
+const (
+	SchemaAdd   SchemaChangeKind = "add"
+	SchemaDrop  SchemaChangeKind = "drop"
+	SchemaAlter SchemaChangeKind = "alter"
+)
+
+// SchemaChange 一项结构变更, Up 是执行变更的语句, Down 是撤销变更的语句, 语句末尾没有分号
+type SchemaChange struct {
+	Kind   SchemaChangeKind
+	Object string // table, column, primary key, index 或 foreign key
+	Table  string
+	Name   string // 列, 索引或外键的名称, 表和主键的变更为空
+	Detail string // 修改的内容, 例如 "类型 varchar(32) -> varchar(64)"
+	Up     []string
+	Down   []string
+}
+
+// SchemaDiff 两个结构之间的差异, Changes 已经按执行顺序排列
+type SchemaDiff struct {
+	Dialect string
+	Changes []SchemaChange
+}
+
+// Diff 比较 db 和 target 的结构, 返回把 db 变成 target 需要的变更, 生成 db 所用数据库类型的语句.
+// 例如 staging.Diff(production) 得到的是 staging 需要执行的变更.
+func (db *Db) Diff(target *Db) (*SchemaDiff, SqlResult) {
+	to, mr := target.Schema()
+	if mr.Fail() {
+		return nil, mr
+	}
+	return db.DiffSchema(to)
+}
+
+// DiffSchema 比较 db 和声明的结构 target, 返回把 db 变成 target 需要的变更.
+// 声明的结构中可以只设置 Column.PrimaryKey 而不设置 TableSchema.PrimaryKey, 索引和外键的名称可以为空.
+func (db *Db) DiffSchema(target *Schema) (*SchemaDiff, SqlResult) {
+	from, mr := db.Schema()
+	if mr.Fail() {
+		return nil, mr
+	}
+	return DiffSchemas(from, target, db.dbType), SqlResult{}
+}
+
+// DiffSchemas 比较两个结构, 返回把 from 变成 to 需要的变更, dialect 是生成语句使用的数据库类型.
+//
+// 索引和外键按内容比较, 名称不同但内容相同的不算变更. SQLite 不能修改列和外键, 这些变更通过重建表完成,
+// 重建时会复制两个结构都有的列的数据, 表上的触发器需要手工重建. 连接启用了外键约束时, 重建前需要关闭外键约束.
+func DiffSchemas(from, to *Schema, dialect string) *SchemaDiff {
+	d := &schemaDiffer{dialect: dialect}
+	for _, t := range to.Tables {
+		newTable := normalizeTable(t)
+		if old := from.Table(t.Name); old != nil {
+			d.diffTable(normalizeTable(*old), newTable)
+		} else {
+			d.createTable(newTable)
+		}
+	}
+	for _, t := range from.Tables {
+		if to.Table(t.Name) == nil {
+			d.dropTable(normalizeTable(t))
+		}
+	}
+	diff := &SchemaDiff{Dialect: dialect}
+	for _, changes := range d.phases {
+		diff.Changes = append(diff.Changes, changes...)
+	}
+	return diff
+}
+
+// Empty 两个结构相同时返回 true
+func (d *SchemaDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// String 返回变更的摘要, 每项变更一行
+func (d *SchemaDiff) String() string {
+	var b strings.Builder
+	for _, c := range d.Changes {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (c SchemaChange) String() string {
+	s := fmt.Sprintf("%s %s %s", c.Kind, c.Object, c.Table)
+	if c.Name != "" {
+		s += "." + c.Name
+	}
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	return s
+}
+
+// UpSQL 返回执行全部变更的脚本
+func (d *SchemaDiff) UpSQL() string {
+	var b strings.Builder
+	for _, c := range d.Changes {
+		writeChange(&b, c, c.Up)
+	}
+	return b.String()
+}
+
+// DownSQL 返回撤销全部变更的脚本, 按相反的顺序执行
+func (d *SchemaDiff) DownSQL() string {
+	var b strings.Builder
+	for i := len(d.Changes) - 1; i >= 0; i-- {
+		writeChange(&b, d.Changes[i], d.Changes[i].Down)
+	}
+	return b.String()
+}
+
+func writeChange(b *strings.Builder, c SchemaChange, statements []string) {
+	b.WriteString("-- ")
+	b.WriteString(c.String())
+	b.WriteByte('\n')
+	for _, s := range statements {
+		b.WriteString(s)
+		if !strings.HasPrefix(s, "--") {
+			b.WriteByte(';')
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+}
+
+// WriteMigration 把变更写到 dir 目录下的迁移文件, 文件名是 Migrator 使用的格式, 例如 20240101120000_add_email.up.mysql.sql.
+// 没有变更时不写任何文件.
+func (d *SchemaDiff) WriteMigration(dir string, version int64, name string) error {
+	if d.Empty() {
+		return nil
+	}
+	suffix := ".sql"
+	if d.Dialect != "" {
+		suffix = "." + d.Dialect + ".sql"
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%d_%s", version, name))
+	if err := os.WriteFile(base+".up"+suffix, []byte(d.UpSQL()), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(base+".down"+suffix, []byte(d.DownSQL()), 0644)
+}
+
+// 变更按阶段排列, 先删除外键和索引, 最后删除表, 撤销时按相反的顺序执行
+const (
+	phaseDropForeignKey = iota
+	phaseDropIndex
+	phaseCreateTable
+	phaseAlterTable
+	phaseCreateIndex
+	phaseAddForeignKey
+	phaseDropTable
+	phaseCount
+)
+
+type schemaDiffer struct {
+	dialect string
+	phases  [phaseCount][]SchemaChange
+}
+
+func (d *schemaDiffer) add(phase int, change SchemaChange) {
+	d.phases[phase] = append(d.phases[phase], change)
+}
+
+func (d *schemaDiffer) q(name string) string {
+	return quoteIdent(d.dialect, name)
+}
+
+func (d *schemaDiffer) createTable(t normalizedTable) {
+	inlineFK := d.dialect == DatabaseTypeSqlite
+	d.add(phaseCreateTable, SchemaChange{Kind: SchemaAdd, Object: "table", Table: t.Name,
+		Up:   []string{d.createTableSQL(t.TableSchema, t.Name, inlineFK)},
+		Down: []string{"DROP TABLE " + d.q(t.Name)},
+	})
+	for _, idx := range t.Indexes {
+		d.add(phaseCreateIndex, SchemaChange{Kind: SchemaAdd, Object: "index", Table: t.Name, Name: d.indexName(t.Name, idx),
+			Up:   []string{d.createIndexSQL(t.Name, idx)},
+			Down: []string{d.dropIndexSQL(t.Name, idx)},
+		})
+	}
+	if !inlineFK {
+		for _, fk := range t.ForeignKeys {
+			d.addForeignKey(t.Name, fk)
+		}
+	}
+}
+
+func (d *schemaDiffer) dropTable(t normalizedTable) {
+	inlineFK := d.dialect == DatabaseTypeSqlite
+	if !inlineFK {
+		for _, fk := range t.ForeignKeys {
+			d.dropForeignKey(t.Name, fk)
+		}
+	}
+	down := []string{d.createTableSQL(t.TableSchema, t.Name, inlineFK)}
+	for _, idx := range t.Indexes {
+		down = append(down, d.createIndexSQL(t.Name, idx))
+	}
+	d.add(phaseDropTable, SchemaChange{Kind: SchemaDrop, Object: "table", Table: t.Name,
+		Up:   []string{"DROP TABLE " + d.q(t.Name)},
+		Down: down,
+	})
+}
+
+func (d *schemaDiffer) diffTable(old, t normalizedTable) {
+	if d.dialect == DatabaseTypeSqlite {
+		if reasons := d.sqliteRebuildReasons(old, t); len(reasons) > 0 {
+			d.add(phaseAlterTable, SchemaChange{Kind: SchemaAlter, Object: "table", Table: t.Name,
+				Detail: "重建表, " + strings.Join(reasons, ", "),
+				Up:     d.rebuildTableSQL(old, t),
+				Down:   d.rebuildTableSQL(t, old),
+			})
+			return
+		}
+	}
+
+	var added, altered, dropped []SchemaChange
+	for _, col := range t.Columns {
+		oldCol := old.Column(col.Name)
+		if oldCol == nil {
+			added = append(added, SchemaChange{Kind: SchemaAdd, Object: "column", Table: t.Name, Name: col.Name,
+				Up:   []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", d.q(t.Name), d.columnDef(col, false))},
+				Down: []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", d.q(t.Name), d.q(col.Name))},
+			})
+			continue
+		}
+		if detail := columnChanges(*oldCol, col); detail != "" {
+			altered = append(altered, SchemaChange{Kind: SchemaAlter, Object: "column", Table: t.Name, Name: col.Name, Detail: detail,
+				Up:   d.alterColumnSQL(t.Name, *oldCol, col),
+				Down: d.alterColumnSQL(t.Name, col, *oldCol),
+			})
+		}
+	}
+	for _, col := range old.Columns {
+		if t.Column(col.Name) == nil {
+			dropped = append(dropped, SchemaChange{Kind: SchemaDrop, Object: "column", Table: t.Name, Name: col.Name,
+				Up:   []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", d.q(t.Name), d.q(col.Name))},
+				Down: []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", d.q(t.Name), d.columnDef(col, false))},
+			})
+		}
+	}
+	d.phases[phaseAlterTable] = append(d.phases[phaseAlterTable], added...)
+	d.phases[phaseAlterTable] = append(d.phases[phaseAlterTable], altered...)
+	if !slices.Equal(old.PrimaryKey, t.PrimaryKey) {
+		d.add(phaseAlterTable, SchemaChange{Kind: SchemaAlter, Object: "primary key", Table: t.Name,
+			Detail: fmt.Sprintf("(%s) -> (%s)", strings.Join(old.PrimaryKey, ", "), strings.Join(t.PrimaryKey, ", ")),
+			Up:     d.alterPrimaryKeySQL(old, t),
+			Down:   d.alterPrimaryKeySQL(t, old),
+		})
+	}
+	d.phases[phaseAlterTable] = append(d.phases[phaseAlterTable], dropped...)
+
+	for _, idx := range old.Indexes {
+		if findIndex(t.Indexes, idx) < 0 {
+			d.add(phaseDropIndex, SchemaChange{Kind: SchemaDrop, Object: "index", Table: t.Name, Name: idx.Name,
+				Up:   []string{d.dropIndexSQL(t.Name, idx)},
+				Down: []string{d.createIndexSQL(t.Name, idx)},
+			})
+		}
+	}
+	for _, idx := range t.Indexes {
+		if findIndex(old.Indexes, idx) < 0 {
+			d.add(phaseCreateIndex, SchemaChange{Kind: SchemaAdd, Object: "index", Table: t.Name, Name: d.indexName(t.Name, idx),
+				Up:   []string{d.createIndexSQL(t.Name, idx)},
+				Down: []string{d.dropIndexSQL(t.Name, idx)},
+			})
+		}
+	}
+	for _, fk := range old.ForeignKeys {
+		if findForeignKey(t.ForeignKeys, fk) < 0 {
+			d.dropForeignKey(t.Name, fk)
+		}
+	}
+	for _, fk := range t.ForeignKeys {
+		if findForeignKey(old.ForeignKeys, fk) < 0 {
+			d.addForeignKey(t.Name, fk)
+		}
+	}
+}
+
+func (d *schemaDiffer) addForeignKey(table string, fk ForeignKey) {
+	d.add(phaseAddForeignKey, SchemaChange{Kind: SchemaAdd, Object: "foreign key", Table: table, Name: fk.Name,
+		Up:   []string{fmt.Sprintf("ALTER TABLE %s ADD %s", d.q(table), d.foreignKeyDef(fk))},
+		Down: []string{d.dropForeignKeySQL(table, fk)},
+	})
+}
+
+func (d *schemaDiffer) dropForeignKey(table string, fk ForeignKey) {
+	d.add(phaseDropForeignKey, SchemaChange{Kind: SchemaDrop, Object: "foreign key", Table: table, Name: fk.Name,
+		Up:   []string{d.dropForeignKeySQL(table, fk)},
+		Down: []string{fmt.Sprintf("ALTER TABLE %s ADD %s", d.q(table), d.foreignKeyDef(fk))},
+	})
+}
+
+func (d *schemaDiffer) dropForeignKeySQL(table string, fk ForeignKey) string {
+	if d.dialect == DatabaseTypeMysql {
+		return fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s", d.q(table), d.q(fk.Name))
+	}
+	return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", d.q(table), d.q(fk.Name))
+}
+
+// sqliteRebuildReasons 返回 SQLite 需要重建表的原因, 不需要重建时返回空
+func (d *schemaDiffer) sqliteRebuildReasons(old, t normalizedTable) []string {
+	var reasons []string
+	for _, col := range t.Columns {
+		oldCol := old.Column(col.Name)
+		switch {
+		case oldCol == nil && (col.PrimaryKey || !col.Nullable && col.Default == nil):
+			reasons = append(reasons, "添加列 "+col.Name)
+		case oldCol != nil && columnChanges(*oldCol, col) != "":
+			reasons = append(reasons, "修改列 "+col.Name)
+		}
+	}
+	for _, col := range old.Columns {
+		if t.Column(col.Name) == nil {
+			reasons = append(reasons, "删除列 "+col.Name)
+		}
+	}
+	if !slices.Equal(old.PrimaryKey, t.PrimaryKey) {
+		reasons = append(reasons, "修改主键")
+	}
+	for _, idx := range old.Indexes {
+		// UNIQUE 约束创建的索引不能单独删除
+		if strings.HasPrefix(idx.Name, "sqlite_autoindex_") && findIndex(t.Indexes, idx) < 0 {
+			reasons = append(reasons, "删除唯一约束 "+strings.Join(idx.Columns, ", "))
+		}
+	}
+	for _, fk := range old.ForeignKeys {
+		if findForeignKey(t.ForeignKeys, fk) < 0 {
+			reasons = append(reasons, "删除外键 "+strings.Join(fk.Columns, ", "))
+		}
+	}
+	for _, fk := range t.ForeignKeys {
+		if findForeignKey(old.ForeignKeys, fk) < 0 {
+			reasons = append(reasons, "添加外键 "+strings.Join(fk.Columns, ", "))
+		}
+	}
+	return reasons
+}
+
+// rebuildTableSQL 按 SQLite 推荐的方式修改表: 创建新表, 复制数据, 删除旧表, 重命名新表, 重建索引
+func (d *schemaDiffer) rebuildTableSQL(old, t normalizedTable) []string {
+	tmp := "_judb_new_" + t.Name
+	var columns []string
+	for _, col := range t.Columns {
+		if old.Column(col.Name) != nil {
+			columns = append(columns, d.q(col.Name))
+		}
+	}
+	list := strings.Join(columns, ", ")
+	statements := []string{
+		d.createTableSQL(t.TableSchema, tmp, true),
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", d.q(tmp), list, list, d.q(t.Name)),
+		"DROP TABLE " + d.q(t.Name),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", d.q(tmp), d.q(t.Name)),
+	}
+	for _, idx := range t.Indexes {
+		statements = append(statements, d.createIndexSQL(t.Name, idx))
+	}
+	return statements
+}
+
+func (d *schemaDiffer) createTableSQL(t TableSchema, name string, withForeignKeys bool) string {
+	inlinePK := d.dialect == DatabaseTypeSqlite && len(t.PrimaryKey) == 1
+	if inlinePK {
+		col := t.Column(t.PrimaryKey[0])
+		inlinePK = col != nil && col.AutoIncrement && strings.EqualFold(col.Type, "INTEGER")
+	}
+	var defs []string
+	for _, col := range t.Columns {
+		defs = append(defs, d.columnDef(col, inlinePK && col.PrimaryKey))
+	}
+	if len(t.PrimaryKey) > 0 && !inlinePK {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", d.identList(t.PrimaryKey)))
+	}
+	if withForeignKeys {
+		for _, fk := range t.ForeignKeys {
+			defs = append(defs, d.foreignKeyDef(fk))
+		}
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n    %s\n)", d.q(name), strings.Join(defs, ",\n    "))
+}
+
+func (d *schemaDiffer) columnDef(col Column, inlinePK bool) string {
+	typ := col.Type
+	def := col.Default
+	identity := false
+	if d.dialect == DatabaseTypePostgres && col.AutoIncrement {
+		if def != nil && strings.HasPrefix(*def, "nextval(") {
+			typ = serialType(typ)
+			def = nil
+		} else if def == nil {
+			identity = true
+		}
+	}
+	b := strings.Builder{}
+	b.WriteString(d.q(col.Name))
+	if typ != "" {
+		b.WriteString(" " + typ)
+	}
+	if identity {
+		b.WriteString(" GENERATED BY DEFAULT AS IDENTITY")
+	}
+	if inlinePK {
+		b.WriteString(" PRIMARY KEY")
+	}
+	if !col.Nullable {
+		b.WriteString(" NOT NULL")
+	}
+	if def != nil {
+		b.WriteString(" DEFAULT " + *def)
+	}
+	if d.dialect == DatabaseTypeMysql && col.AutoIncrement {
+		b.WriteString(" AUTO_INCREMENT")
+	}
+	return b.String()
+}
+
+func (d *schemaDiffer) alterColumnSQL(table string, old, col Column) []string {
+	t := d.q(table)
+	if d.dialect == DatabaseTypeMysql {
+		return []string{fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s", t, d.columnDef(col, false))}
+	}
+	prefix := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s ", t, d.q(col.Name))
+	var statements []string
+	if normalizeType(old.Type) != normalizeType(col.Type) {
+		statements = append(statements, prefix+fmt.Sprintf("TYPE %s USING %s::%s", col.Type, d.q(col.Name), col.Type))
+	}
+	oldIdentity := old.AutoIncrement && old.Default == nil
+	identity := col.AutoIncrement && col.Default == nil
+	if oldIdentity && !identity {
+		statements = append(statements, prefix+"DROP IDENTITY")
+	}
+	if !equalDefault(old.Default, col.Default) {
+		if col.Default == nil {
+			statements = append(statements, prefix+"DROP DEFAULT")
+		} else {
+			statements = append(statements, prefix+"SET DEFAULT "+*col.Default)
+		}
+	}
+	if old.Nullable != col.Nullable {
+		if col.Nullable {
+			statements = append(statements, prefix+"DROP NOT NULL")
+		} else {
+			statements = append(statements, prefix+"SET NOT NULL")
+		}
+	}
+	if identity && !oldIdentity {
+		statements = append(statements, prefix+"ADD GENERATED BY DEFAULT AS IDENTITY")
+	}
+	return statements
+}
+
+func (d *schemaDiffer) alterPrimaryKeySQL(old, t normalizedTable) []string {
+	var statements []string
+	if len(old.PrimaryKey) > 0 {
+		if d.dialect == DatabaseTypeMysql {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP PRIMARY KEY", d.q(t.Name)))
+		} else {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", d.q(t.Name), d.q(old.primaryKeyName)))
+		}
+	}
+	if len(t.PrimaryKey) > 0 {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s)", d.q(t.Name), d.identList(t.PrimaryKey)))
+	}
+	return statements
+}
+
+func (d *schemaDiffer) foreignKeyDef(fk ForeignKey) string {
+	s := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)", d.identList(fk.Columns), d.q(fk.RefTable), d.identList(fk.RefColumns))
+	if d.dialect != DatabaseTypeSqlite {
+		s = "CONSTRAINT " + d.q(fk.Name) + " " + s
+	}
+	if fk.OnUpdate != "NO ACTION" {
+		s += " ON UPDATE " + fk.OnUpdate
+	}
+	if fk.OnDelete != "NO ACTION" {
+		s += " ON DELETE " + fk.OnDelete
+	}
+	return s
+}
+
+// indexName SQLite 的 UNIQUE 约束创建的索引名称是保留的, 生成索引时使用新的名称
+func (d *schemaDiffer) indexName(table string, idx Index) string {
+	if idx.Name != "" && !strings.HasPrefix(idx.Name, "sqlite_autoindex_") {
+		return idx.Name
+	}
+	prefix := "idx_"
+	if idx.Unique {
+		prefix = "uk_"
+	}
+	return prefix + table + "_" + strings.Join(idx.Columns, "_")
+}
+
+func (d *schemaDiffer) createIndexSQL(table string, idx Index) string {
+	name := d.indexName(table, idx)
+	var columns []string
+	for _, col := range idx.Columns {
+		switch {
+		case col == "":
+			return fmt.Sprintf("-- 表达式索引 %s 不能自动生成, 需要手工添加", name)
+		case strings.ContainsAny(col, "( "):
+			columns = append(columns, "("+col+")")
+		default:
+			columns = append(columns, d.q(col))
+		}
+	}
+	if d.dialect == DatabaseTypePostgres && idx.Constraint {
+		return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s)", d.q(table), d.q(name), strings.Join(columns, ", "))
+	}
+	unique := ""
+	if idx.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)", unique, d.q(name), d.q(table), strings.Join(columns, ", "))
+}
+
+// dropIndexSQL PostgreSQL 的 UNIQUE 约束创建的索引不能用 DROP INDEX 删除, 需要删除约束
+func (d *schemaDiffer) dropIndexSQL(table string, idx Index) string {
+	switch {
+	case d.dialect == DatabaseTypeMysql:
+		return fmt.Sprintf("DROP INDEX %s ON %s", d.q(d.indexName(table, idx)), d.q(table))
+	case d.dialect == DatabaseTypePostgres && idx.Constraint:
+		return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", d.q(table), d.q(d.indexName(table, idx)))
+	}
+	return "DROP INDEX " + d.q(d.indexName(table, idx))
+}
+
+func (d *schemaDiffer) identList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = d.q(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// normalizedTable 是比较时使用的表结构, 主键索引从 Indexes 中移出, 名称和外键的动作已经补齐
+type normalizedTable struct {
+	TableSchema
+	primaryKeyName string
+}
+
+func normalizeTable(t TableSchema) normalizedTable {
+	n := normalizedTable{TableSchema: TableSchema{Name: t.Name, PrimaryKey: t.PrimaryKey}, primaryKeyName: t.Name + "_pkey"}
+	if len(n.PrimaryKey) == 0 {
+		for _, col := range t.Columns {
+			if col.PrimaryKey {
+				n.PrimaryKey = append(n.PrimaryKey, col.Name)
+			}
+		}
+	}
+	for _, col := range t.Columns {
+		col.PrimaryKey = slices.Contains(n.PrimaryKey, col.Name)
+		n.Columns = append(n.Columns, col)
+	}
+	for _, idx := range t.Indexes {
+		if idx.Primary {
+			n.primaryKeyName = idx.Name
+			continue
+		}
+		n.Indexes = append(n.Indexes, idx)
+	}
+	for _, fk := range t.ForeignKeys {
+		if fk.Name == "" {
+			fk.Name = "fk_" + t.Name + "_" + strings.Join(fk.Columns, "_")
+		}
+		fk.OnUpdate = normalizeAction(fk.OnUpdate)
+		fk.OnDelete = normalizeAction(fk.OnDelete)
+		n.ForeignKeys = append(n.ForeignKeys, fk)
+	}
+	return n
+}
+
+func normalizeAction(action string) string {
+	if action == "" {
+		return "NO ACTION"
+	}
+	return strings.ToUpper(action)
+}
+
+var intDisplayWidth = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|integer|bigint)\(\d+\)`)
+
+// normalizeType 比较类型时忽略大小写, 多余的空格和 MySQL 整数类型的显示宽度
+func normalizeType(typ string) string {
+	typ = strings.Join(strings.Fields(strings.ToLower(typ)), " ")
+	return intDisplayWidth.ReplaceAllString(typ, "$1")
+}
+
+// columnChanges 返回列的修改内容, 没有修改时返回空串
+func columnChanges(old, col Column) string {
+	var changes []string
+	if normalizeType(old.Type) != normalizeType(col.Type) {
+		changes = append(changes, fmt.Sprintf("类型 %s -> %s", old.Type, col.Type))
+	}
+	if old.Nullable != col.Nullable {
+		changes = append(changes, fmt.Sprintf("允许 NULL %t -> %t", old.Nullable, col.Nullable))
+	}
+	if !equalDefault(old.Default, col.Default) {
+		changes = append(changes, fmt.Sprintf("默认值 %s -> %s", defaultText(old.Default), defaultText(col.Default)))
+	}
+	if old.AutoIncrement != col.AutoIncrement {
+		changes = append(changes, fmt.Sprintf("自动增长 %t -> %t", old.AutoIncrement, col.AutoIncrement))
+	}
+	return strings.Join(changes, ", ")
+}
+
+func equalDefault(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func defaultText(def *string) string {
+	if def == nil {
+		return "无"
+	}
+	return *def
+}
+
+func findIndex(indexes []Index, idx Index) int {
+	for i, other := range indexes {
+		if other.Unique == idx.Unique && slices.Equal(other.Columns, idx.Columns) {
+			return i
+		}
+	}
+	return -1
+}
+
+func findForeignKey(fks []ForeignKey, fk ForeignKey) int {
+	for i, other := range fks {
+		if other.RefTable == fk.RefTable && other.OnUpdate == fk.OnUpdate && other.OnDelete == fk.OnDelete &&
+			slices.Equal(other.Columns, fk.Columns) && slices.Equal(other.RefColumns, fk.RefColumns) {
+			return i
+		}
+	}
+	return -1
+}
+
+func serialType(typ string) string {
+	switch strings.ToLower(typ) {
+	case "integer", "int", "int4":
+		return "serial"
+	case "bigint", "int8":
+		return "bigserial"
+	case "smallint", "int2":
+		return "smallserial"
+	}
+	return typ
+}
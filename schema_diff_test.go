@@ -0,0 +1,188 @@
+package judb
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func openDiffDb(t *testing.T, name string, statements ...string) *Db {
+	t.Helper()
+	db := &Db{}
+	if !db.OpenSqlite3(filepath.Join(t.TempDir(), name), "") {
+		t.Fatal("open sqlite failed")
+	}
+	t.Cleanup(db.Close)
+	for _, s := range statements {
+		if mr := db.Exec(s); mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+	}
+	return db
+}
+
+func execScript(t *testing.T, db *Db, script string) {
+	t.Helper()
+	for _, s := range splitStatements(db.dbType, script) {
+		if mr := db.Exec(s); mr.Fail() {
+			t.Fatalf("%s: %s", s, mr.Error)
+		}
+	}
+}
+
+func changeSummary(diff *SchemaDiff) []string {
+	return strings.Split(strings.TrimSuffix(diff.String(), "\n"), "\n")
+}
+
+func assertNoDiff(t *testing.T, db, target *Db) {
+	t.Helper()
+	diff, mr := db.Diff(target)
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if !diff.Empty() {
+		t.Fatalf("unexpected changes:\n%s", diff)
+	}
+}
+
+func TestSqliteDiff(t *testing.T) {
+	from := openDiffDb(t, "from.db",
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL)",
+		"CREATE TABLE old (v INTEGER)",
+		"CREATE INDEX idx_old_v ON old (v)",
+		"INSERT INTO users (name) VALUES ('a'), ('b')",
+	)
+	to := openDiffDb(t, "to.db",
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, age INTEGER)",
+		"CREATE INDEX idx_users_name ON users (name)",
+		"CREATE TABLE posts (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE, title TEXT DEFAULT '')",
+	)
+	original := openDiffDb(t, "original.db",
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL)",
+		"CREATE TABLE old (v INTEGER)",
+		"CREATE INDEX idx_old_v ON old (v)",
+	)
+
+	diff, mr := from.Diff(to)
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	want := []string{
+		"add table posts",
+		"add column users.age",
+		"add index users.idx_users_name",
+		"drop table old",
+	}
+	if got := changeSummary(diff); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %q, want %q", got, want)
+	}
+	up := diff.UpSQL()
+	for _, s := range []string{
+		`ALTER TABLE "users" ADD COLUMN "age" INTEGER;`,
+		`CREATE INDEX "idx_users_name" ON "users" ("name");`,
+		`FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE`,
+		`DROP TABLE "old";`,
+	} {
+		if !strings.Contains(up, s) {
+			t.Errorf("UpSQL does not contain %s:\n%s", s, up)
+		}
+	}
+	down := diff.DownSQL()
+	if strings.Index(down, `CREATE TABLE "old"`) > strings.Index(down, `DROP TABLE "posts"`) {
+		t.Errorf("DownSQL is not in reverse order:\n%s", down)
+	}
+
+	execScript(t, from, up)
+	assertNoDiff(t, from, to)
+	execScript(t, from, down)
+	assertNoDiff(t, from, original)
+	var count int
+	if err := from.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 2 {
+		t.Fatalf("users count = %d, %v", count, err)
+	}
+}
+
+func TestSqliteDiffRebuild(t *testing.T) {
+	from := openDiffDb(t, "from.db",
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, email TEXT UNIQUE, note TEXT)",
+		"CREATE INDEX idx_users_name ON users (name)",
+		"INSERT INTO users (name, email, note) VALUES ('a', 'a@x', 'n1'), ('b', 'b@x', 'n2')",
+	)
+	to := openDiffDb(t, "to.db",
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, email TEXT)",
+		"CREATE INDEX idx_users_name ON users (name)",
+	)
+	diff, mr := from.Diff(to)
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	want := []string{"alter table users: 重建表, 修改列 name, 删除列 note, 删除唯一约束 email"}
+	if got := changeSummary(diff); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %q, want %q", got, want)
+	}
+
+	execScript(t, from, diff.UpSQL())
+	assertNoDiff(t, from, to)
+	var name, email string
+	if err := from.QueryRow("SELECT name, email FROM users WHERE id = 2").Scan(&name, &email); err != nil {
+		t.Fatal(err)
+	}
+	if name != "b" || email != "b@x" {
+		t.Fatalf("row 2 = %s, %s", name, email)
+	}
+
+	// 撤销时 note 列的数据已经丢失, 只恢复结构
+	execScript(t, from, diff.DownSQL())
+	indexes, mr := from.Indexes("users")
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	var unique []string
+	for _, idx := range indexes {
+		if idx.Unique {
+			unique = append(unique, idx.Columns...)
+		}
+	}
+	if !reflect.DeepEqual(unique, []string{"email"}) {
+		t.Fatalf("indexes after down = %+v", indexes)
+	}
+}
+
+func TestPostgresUniqueConstraintDiff(t *testing.T) {
+	from := &Schema{Tables: []TableSchema{{
+		Name:    "users",
+		Columns: []Column{{Name: "id", Type: "integer", PrimaryKey: true}, {Name: "email", Type: "text"}, {Name: "name", Type: "text"}},
+		Indexes: []Index{
+			{Name: "users_email_key", Columns: []string{"email"}, Unique: true, Constraint: true},
+			{Name: "users_name_idx", Columns: []string{"name"}},
+		},
+	}}}
+	to := &Schema{Tables: []TableSchema{{
+		Name:    "users",
+		Columns: from.Tables[0].Columns,
+		Indexes: []Index{{Name: "users_name_key", Columns: []string{"name"}, Unique: true, Constraint: true}},
+	}}}
+	diff := DiffSchemas(from, to, DatabaseTypePostgres)
+	var up, down []string
+	for _, c := range diff.Changes {
+		up = append(up, c.Up...)
+		down = append(down, c.Down...)
+	}
+	wantUp := []string{
+		`ALTER TABLE "users" DROP CONSTRAINT "users_email_key"`,
+		`DROP INDEX "users_name_idx"`,
+		`ALTER TABLE "users" ADD CONSTRAINT "users_name_key" UNIQUE ("name")`,
+	}
+	wantDown := []string{
+		`ALTER TABLE "users" ADD CONSTRAINT "users_email_key" UNIQUE ("email")`,
+		`CREATE INDEX "users_name_idx" ON "users" ("name")`,
+		`ALTER TABLE "users" DROP CONSTRAINT "users_name_key"`,
+	}
+	if !reflect.DeepEqual(up, wantUp) {
+		t.Errorf("up = %q, want %q", up, wantUp)
+	}
+	if !reflect.DeepEqual(down, wantDown) {
+		t.Errorf("down = %q, want %q", down, wantDown)
+	}
+}
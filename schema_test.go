@@ -0,0 +1,100 @@
+package judb
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAppendIndexColumn(t *testing.T) {
+	rows := []struct {
+		name, column    string
+		unique, primary bool
+	}{
+		{"t_pkey", "id", true, true},
+		{"t_a_b", "a", false, false},
+		{"t_a_b", "b", false, false},
+		{"t_lower", "lower(name)", true, false},
+	}
+	var indexes []Index
+	for _, r := range rows {
+		indexes = appendIndexColumn(indexes, r.name, r.column, r.unique, r.primary)
+	}
+	want := []Index{
+		{Name: "t_pkey", Columns: []string{"id"}, Unique: true, Primary: true},
+		{Name: "t_a_b", Columns: []string{"a", "b"}},
+		{Name: "t_lower", Columns: []string{"lower(name)"}, Unique: true},
+	}
+	if !reflect.DeepEqual(indexes, want) {
+		t.Fatalf("indexes = %+v, want %+v", indexes, want)
+	}
+}
+
+func TestSqliteIndexes(t *testing.T) {
+	var db Db
+	if !db.OpenSqlite3(filepath.Join(t.TempDir(), "schema.db"), "") {
+		t.Fatal("open sqlite failed")
+	}
+	defer db.Close()
+	for _, s := range []string{
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, a INTEGER, b TEXT, c TEXT UNIQUE)",
+		"CREATE INDEX t_b_a ON t (b, a)",
+	} {
+		if mr := db.Exec(s); mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+	}
+	indexes, err := db.indexes("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Index{
+		{Name: "sqlite_autoindex_t_1", Columns: []string{"c"}, Unique: true, Constraint: true},
+		{Name: "t_b_a", Columns: []string{"b", "a"}},
+	}
+	if !reflect.DeepEqual(indexes, want) {
+		t.Fatalf("indexes = %+v, want %+v", indexes, want)
+	}
+}
+
+// TestPostgresIndexes 需要设置 JUDB_TEST_POSTGRES_DSN, 例如 "host=localhost user=postgres dbname=test sslmode=disable"
+func TestPostgresIndexes(t *testing.T) {
+	dsn := os.Getenv("JUDB_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("没有设置 JUDB_TEST_POSTGRES_DSN")
+	}
+	d, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := Db{db: d, dbType: DatabaseTypePostgres}
+	defer db.Close()
+	// 临时表只在一个连接中可见, 限制连接池只使用一个连接
+	d.SetMaxOpenConns(1)
+	for _, s := range []string{
+		"CREATE TEMP TABLE judb_ix (id int PRIMARY KEY, a int, b text, c text, d text CONSTRAINT judb_ix_d_key UNIQUE)",
+		"CREATE INDEX judb_ix_b_a ON judb_ix (b, a)",
+		"CREATE UNIQUE INDEX judb_ix_c ON judb_ix (c) INCLUDE (a)",
+		"CREATE INDEX judb_ix_lower ON judb_ix (lower(b))",
+	} {
+		if mr := db.Exec(s); mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+	}
+	indexes, err := db.indexes("judb_ix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Index{
+		{Name: "judb_ix_b_a", Columns: []string{"b", "a"}},
+		{Name: "judb_ix_c", Columns: []string{"c"}, Unique: true},
+		{Name: "judb_ix_d_key", Columns: []string{"d"}, Unique: true, Constraint: true},
+		{Name: "judb_ix_lower", Columns: []string{"lower(b)"}},
+		{Name: "judb_ix_pkey", Columns: []string{"id"}, Unique: true, Primary: true},
+	}
+	if !reflect.DeepEqual(indexes, want) {
+		t.Fatalf("indexes = %+v, want %+v", indexes, want)
+	}
+}
@@ -0,0 +1,143 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jsuserapp/ju"
+)
+
+// SlowQueryOptions 慢查询日志的参数
+type SlowQueryOptions struct {
+	Threshold  time.Duration // 耗时大于等于这个值的语句会被记录, 0 表示关闭慢查询日志
+	Tab        string        // 日志表的名称, 记录写入 log_<Tab>, 和 LogToRed 等函数的 tab 参数相同
+	RedactArgs bool          // 不记录参数的值, 只记录参数的个数
+	Explain    bool          // 同时记录语句的执行计划, 事务中的语句不记录
+}
+
+// SetSlowQuery 设置慢查询日志, 记录写入日志系统绑定的数据库或文件, 参见 SetLogDb 和 SetLogPath.
+// 记录的内容是 JSON 格式, 包括语句, 参数, 耗时, 影响的行数和执行计划, trace 字段是调用 Db 函数的位置.
+// 这个函数和 AddHook 一样, 需要在使用 Db 之前调用.
+func (db *Db) SetSlowQuery(opts SlowQueryOptions) {
+	if db.slowQuery == nil {
+		db.slowQuery = &slowQueryHook{db: db}
+		db.AddHook(db.slowQuery)
+	}
+	db.slowQuery.opts = opts
+}
+
+type slowQueryHook struct {
+	db   *Db
+	opts SlowQueryOptions
+}
+
+type slowQueryRecord struct {
+	Op           string        `json:"op"`
+	SQL          string        `json:"sql,omitempty"`
+	Args         []interface{} `json:"args,omitempty"`
+	DurationMs   float64       `json:"duration_ms"`
+	RowsAffected int64         `json:"rows_affected"`
+	InTx         bool          `json:"in_tx,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	Explain      []string      `json:"explain,omitempty"`
+}
+
+func (h *slowQueryHook) Before(ctx context.Context, _ *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *slowQueryHook) After(_ context.Context, event *QueryEvent) {
+	if h.opts.Threshold <= 0 || event.Duration < h.opts.Threshold {
+		return
+	}
+	record := slowQueryRecord{
+		Op:           event.Op,
+		SQL:          event.SQL,
+		Args:         event.Args,
+		DurationMs:   float64(event.Duration.Microseconds()) / 1000,
+		RowsAffected: event.RowsAffected,
+		InTx:         event.InTx,
+	}
+	if h.opts.RedactArgs && len(event.Args) > 0 {
+		record.Args = make([]interface{}, len(event.Args))
+		for i := range record.Args {
+			record.Args[i] = "?"
+		}
+	}
+	if event.Result != nil {
+		record.Error = event.Result.Error
+	}
+	if h.opts.Explain && !event.InTx {
+		record.Explain = h.explain(event)
+	}
+	saveLog(logParam.DbType, h.opts.Tab, callerTrace(), ju.ColorYellow, ju.JsonEncodeString(record))
+}
+
+// explain 获取语句的执行计划, 只支持增删改查语句, 失败时返回错误信息. EXPLAIN 不执行语句, 在只读连接池上运行, 不占用写连接
+func (h *slowQueryHook) explain(event *QueryEvent) []string {
+	switch event.Operation {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE", "WITH":
+	default:
+		return nil
+	}
+	prefix := "EXPLAIN "
+	if event.Dialect == DatabaseTypeSqlite {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+	rows, err := h.db.reader().Query(prefix+event.SQL, event.Args...)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	columns, err := rows.Columns()
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var plan []string
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return append(plan, err.Error())
+		}
+		fields := make([]string, len(values))
+		for i, val := range values {
+			fields[i] = val.String
+		}
+		plan = append(plan, strings.Join(fields, " | "))
+	}
+	return plan
+}
+
+// callerTrace 返回调用 judb 的位置, 格式和 ju.GetTrace 相同, 跳过 judb 包内部的调用
+func callerTrace() string {
+	pcs := make([]uintptr, 32)
+	count := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:count])
+	for skip := 0; ; skip++ {
+		frame, more := frames.Next()
+		// 包内的测试函数也算调用者
+		if !isJudbFunction(frame.Function) || strings.HasSuffix(frame.File, "_test.go") {
+			// ju.GetTrace 自身还多一层调用
+			return ju.GetTrace(skip + 1)
+		}
+		if !more {
+			return fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+		}
+	}
+}
+
+func isJudbFunction(name string) bool {
+	const pkg = "github.com/jsuserapp/judb."
+	return strings.HasPrefix(name, pkg)
+}
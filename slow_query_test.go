@@ -0,0 +1,145 @@
+package judb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLogDb 把日志系统绑定到一个新的 SQLite 数据库, 测试结束后恢复原来的设置
+func useLogDb(t *testing.T) *Db {
+	t.Helper()
+	logDb := &Db{}
+	if !logDb.OpenSqlite3(filepath.Join(t.TempDir(), "log.db"), "") {
+		t.Fatal("open log db failed")
+	}
+	dbType, db, save := logParam.DbType, logParam.LogDb, logParam.Save.Load()
+	SetLogDb(LogDbTypeSqlite, logDb.db, true)
+	t.Cleanup(func() {
+		logParam.DbType, logParam.LogDb = dbType, db
+		logParam.Save.Store(save)
+		logDb.Close()
+	})
+	return logDb
+}
+
+type slowLogRow struct {
+	trace, color string
+	record       slowQueryRecord
+}
+
+func slowLogRows(t *testing.T, logDb *Db, tab string) []slowLogRow {
+	t.Helper()
+	var result []slowLogRow
+	err := logDb.collect("SELECT trace, color, log FROM log_"+tab+" ORDER BY id", nil, func(rows *sql.Rows) error {
+		var row slowLogRow
+		var text string
+		if err := rows.Scan(&row.trace, &row.color, &text); err != nil {
+			return err
+		}
+		result = append(result, row)
+		return json.Unmarshal([]byte(text), &result[len(result)-1].record)
+	})
+	if err != nil && !strings.Contains(err.Error(), "no such table") {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func openSlowDb(t *testing.T) *Db {
+	t.Helper()
+	db := &Db{}
+	// judb_sleep(ms) 用于构造耗时确定的慢查询
+	db.SetSqliteFunctions(&SqliteFunctions{Scalars: []SqliteFunc{{
+		Name: "judb_sleep",
+		Impl: func(ms int64) int64 {
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			return ms
+		},
+	}}})
+	if !db.OpenSqlite3(filepath.Join(t.TempDir(), "slow.db"), "") {
+		t.Fatal("open sqlite failed")
+	}
+	t.Cleanup(db.Close)
+	if mr := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	return db
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	logDb := useLogDb(t)
+	db := openSlowDb(t)
+	db.SetSlowQuery(SlowQueryOptions{Threshold: 40 * time.Millisecond, Tab: "slow"})
+
+	db.Exec("INSERT INTO items (name) VALUES (?)", "fast")
+	// SQLite 在读取第一行时才执行语句, QueryRow 的 After 在 Scan 之前调用, 所以使用 Query
+	if mr := db.Query("SELECT judb_sleep(?)", func(rows *sql.Rows) {
+		for rows.Next() {
+		}
+	}, 60); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	mr := db.Exec("UPDATE items SET name = ? WHERE judb_sleep(50) > 0", "slow")
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+
+	rows := slowLogRows(t, logDb, "slow")
+	if len(rows) != 2 {
+		t.Fatalf("logged %d rows, want 2: %+v", len(rows), rows)
+	}
+	r := rows[0].record
+	if r.Op != OpQuery || r.SQL != "SELECT judb_sleep(?)" || r.DurationMs < 40 || len(r.Args) != 1 || r.Args[0] != float64(60) {
+		t.Fatalf("query record = %+v", r)
+	}
+	r = rows[1].record
+	if r.Op != OpExec || r.RowsAffected != 1 || r.Error != "" || r.Args[0] != "slow" || r.Explain != nil {
+		t.Fatalf("exec record = %+v", r)
+	}
+	// trace 是调用 Db 函数的位置, 不是 judb 内部的位置
+	if !strings.Contains(rows[1].trace, "slow_query_test.go") || rows[1].color == "" {
+		t.Fatalf("trace = %s, color = %s", rows[1].trace, rows[1].color)
+	}
+
+	// 阈值为 0 时关闭慢查询日志
+	db.SetSlowQuery(SlowQueryOptions{Tab: "slow"})
+	db.Exec("UPDATE items SET name = ? WHERE judb_sleep(50) > 0", "off")
+	if rows = slowLogRows(t, logDb, "slow"); len(rows) != 2 {
+		t.Fatalf("logged %d rows after disabling, want 2", len(rows))
+	}
+}
+
+func TestSlowQueryRedactAndExplain(t *testing.T) {
+	logDb := useLogDb(t)
+	db := openSlowDb(t)
+	db.SetErrorReporter(SilentReporter{})
+	db.SetSlowQuery(SlowQueryOptions{Threshold: time.Nanosecond, Tab: "slow_explain", RedactArgs: true, Explain: true})
+
+	db.Query("SELECT name FROM items WHERE id = ? AND name = ?", func(rows *sql.Rows) {}, 1, "secret")
+	db.Exec("INSERT INTO missing VALUES (?)", "secret")
+
+	rows := slowLogRows(t, logDb, "slow_explain")
+	if len(rows) != 2 {
+		t.Fatalf("logged %d rows, want 2", len(rows))
+	}
+	r := rows[0].record
+	if len(r.Args) != 2 || r.Args[0] != "?" || r.Args[1] != "?" {
+		t.Fatalf("args = %v, want redacted", r.Args)
+	}
+	if len(r.Explain) == 0 || !strings.Contains(strings.Join(r.Explain, "\n"), "items") {
+		t.Fatalf("explain = %q", r.Explain)
+	}
+	r = rows[1].record
+	if !strings.Contains(r.Error, "no such table") || r.RowsAffected != -1 {
+		t.Fatalf("failed exec record = %+v", r)
+	}
+	for _, row := range rows {
+		if strings.Contains(row.trace+strings.Join(row.record.Explain, ""), "secret") {
+			t.Fatalf("record leaks an argument: %+v", row)
+		}
+	}
+}
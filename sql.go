@@ -1,6 +1,7 @@
 package judb
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
@@ -10,8 +11,9 @@ import (
 	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/jsuserapp/ju"
 	"github.com/jsuserapp/judb/postgres"
 	"github.com/mattn/go-sqlite3"
@@ -24,8 +26,15 @@ const (
 )
 
 type Db struct {
-	dbType string
-	db     *sql.DB
+	dbType      string
+	db          *sql.DB
+	readDb      *sql.DB // SqliteOptions.ReadConns 大于 0 时的只读连接池
+	cipher      *sqliteCipher
+	sqliteFuncs *SqliteFunctions
+	credential  CredentialProvider
+	hooks       []Hook
+	slowQuery   *slowQueryHook
+	reporter    ErrorReporter
 }
 
 var errSkip = 1
@@ -44,6 +53,8 @@ func SetErrorSkip(skip int) {
 // dbpath: example ./data/log.db
 //
 // params: 如果不需要修改参数，可以设置为空串，此时它的值是 _mutex=full&_journal_mode=WAL
+//
+// 文件不能打开, 或者不是 SQLite 数据库时输出错误并返回 false. 需要设置更多参数时使用 OpenSqlite3Options
 func (db *Db) OpenSqlite3(dbpath, params string) bool {
 	if db.db != nil {
 		return true
@@ -51,10 +62,20 @@ func (db *Db) OpenSqlite3(dbpath, params string) bool {
 	if params == "" {
 		params = "_mutex=full&_journal_mode=WAL"
 	}
-	d, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?%s", dbpath, params))
-	db.db = d
+	pool := openSqliteDB(db.sqliteFuncs, false, fmt.Sprintf("file:%s?%s", dbpath, params))
+	// 连接池在第一次使用时才打开文件, 这里立即连接并读取一次结构, 文件不是数据库或者是加密的数据库时在打开时报告
+	err := pool.Ping()
+	if err == nil {
+		var n int
+		err = pool.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&n)
+	}
+	if err != nil {
+		_ = pool.Close()
+		return !db.reportError(errSkip, sqliteEncryptedError(err, ""))
+	}
+	db.db = pool
 	db.dbType = DatabaseTypeSqlite
-	return !ju.LogErrorTrace(err, errSkip)
+	return true
 }
 
 // MakeTLSConfig Mysql 使用证书的方式和 PostgreSQL 不太一样，需要单独注册
@@ -92,7 +113,8 @@ func MakeTLSConfig(clientKeyPath, clientCertPath, caCertPath, serverName string)
 	return cfg
 }
 
-// MakeMysqlConfig 这个函数是为了简化 Config 的构造
+// MakeMysqlConfig 这个函数是为了简化 Config 的构造, 时间列按驱动的默认方式返回 []byte.
+// 需要解析时间或者设置更多参数时使用 NewMysqlOptions
 func MakeMysqlConfig(host, port, dbname, user, pass string) *mysql.Config {
 	cfg := &mysql.Config{
 		Net:    "tcp",
@@ -134,26 +156,73 @@ func (db *Db) OpenMysql(cfg *mysql.Config) bool {
 	if db.db != nil {
 		return true
 	}
-	d, err := sql.Open("mysql", cfg.FormatDSN())
-	db.db = d
+	if db.credential == nil {
+		d, err := sql.Open("mysql", cfg.FormatDSN())
+		db.db = d
+		db.dbType = DatabaseTypeMysql
+		return !db.reportError(errSkip, err)
+	}
+	// 驱动在每次新建连接前调用 BeforeConnect, 传入的是配置的副本
+	provider := db.credential
+	cfg = cfg.Clone()
+	err := cfg.Apply(mysql.BeforeConnect(func(ctx context.Context, c *mysql.Config) error {
+		pass, err := provider.Password(ctx)
+		c.Passwd = pass
+		return err
+	}))
+	if db.reportError(errSkip, err) {
+		return false
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if db.reportError(errSkip, err) {
+		return false
+	}
+	db.db = sql.OpenDB(connector)
 	db.dbType = DatabaseTypeMysql
-	return !ju.LogErrorTrace(err, errSkip)
+	return true
 }
 
 type name struct {
 }
 
 func (db *Db) OpenPostgres(cfg *postgres.Config) bool {
+	if db.reportError(errSkip, cfg.Validate()) {
+		return false
+	}
 	dsn := cfg.FormatDSN()
-	d, err := sql.Open("pgx", dsn)
-	db.db = d
+	if cfg.TLSConfig == nil && db.credential == nil {
+		d, err := sql.Open("pgx", dsn)
+		db.db = d
+		db.dbType = DatabaseTypePostgres
+		return !db.reportError(errSkip, err)
+	}
+	// 使用自定义的 TLS 配置或者密码提供者时, 需要通过 ConnConfig 打开, 否则 pgx 会从 DSN 里的证书路径加载一次证书,
+	// 密码也只在打开时读取一次
+	connCfg, err := pgx.ParseConfig(dsn)
+	if db.reportError(errSkip, err) {
+		return false
+	}
+	if cfg.TLSConfig != nil {
+		connCfg.TLSConfig = cfg.TLSConfig
+		connCfg.Fallbacks = nil
+	}
+	var opts []stdlib.OptionOpenDB
+	if provider := db.credential; provider != nil {
+		// pgx 传入的是 ConnConfig 的浅拷贝, 可以直接修改
+		opts = append(opts, stdlib.OptionBeforeConnect(func(ctx context.Context, cc *pgx.ConnConfig) error {
+			pass, err := provider.Password(ctx)
+			cc.Password = pass
+			return err
+		}))
+	}
+	db.db = stdlib.OpenDB(*connCfg, opts...)
 	db.dbType = DatabaseTypePostgres
-	return !ju.LogErrorTrace(err, errSkip)
+	return true
 }
 func (db *Db) OutputConnectInfo() bool {
 	// sql.Open 不会立即建立连接，Ping() 会
 	err := db.db.Ping()
-	if ju.LogErrorTrace(err, 1) {
+	if db.reportError(1, err) {
 		return false
 	}
 
@@ -164,7 +233,7 @@ func (db *Db) OutputConnectInfo() bool {
 	// 现在可以执行查询了
 	var version string
 	err = db.QueryRow(sqlCase).Scan(&version)
-	if ju.LogErrorTrace(err, 1) {
+	if db.reportError(1, err) {
 		return false
 	}
 	if db.dbType == DatabaseTypeSqlite {
@@ -180,6 +249,17 @@ func (db *Db) Close() {
 	if db.db != nil {
 		_ = db.db.Close()
 	}
+	if db.readDb != nil {
+		_ = db.readDb.Close()
+	}
+}
+
+// reader 返回读取使用的连接池, 没有单独的只读连接池时和写入相同
+func (db *Db) reader() *sql.DB {
+	if db.readDb != nil {
+		return db.readDb
+	}
+	return db.db
 }
 
 type SqlResult struct {
@@ -229,45 +309,111 @@ func (db *Db) Query(sqlCase string, qc QueryCall, v ...interface{}) SqlResult {
 	if db.db == nil {
 		mr.Code = "-1"
 		mr.Error = "数据库对象不可用 nil"
-		ju.OutputColor(errSkip, "red", mr.Error)
+		db.reportError(errSkip, errors.New(mr.Error))
 		return mr
 	}
-	rows, err := db.db.Query(sqlCase, v...)
-	if ju.LogErrorTrace(err, errSkip) {
+	ctx, event := db.beforeHooks(context.Background(), OpQuery, sqlCase, v, false)
+	rows, err := db.reader().QueryContext(ctx, event.SQL, event.Args...)
+	if db.reportError(errSkip, err) {
 		mr.SetError(err)
 	} else {
 		qc(rows)
 		_ = rows.Close()
 	}
+	db.afterHooks(ctx, event, &mr)
 	return mr
 }
+
+// query 和 Query 相同, 但是不输出错误, qc 返回的错误也作为结果返回, 由调用者决定如何输出
+func (db *Db) query(sqlCase string, qc func(rows *sql.Rows) error, v ...interface{}) error {
+	if db.db == nil {
+		return errors.New("数据库对象不可用 nil")
+	}
+	ctx, event := db.beforeHooks(context.Background(), OpQuery, sqlCase, v, false)
+	rows, err := db.reader().QueryContext(ctx, event.SQL, event.Args...)
+	if err == nil {
+		err = qc(rows)
+		_ = rows.Close()
+	}
+	mr := NewSqlResult(err)
+	db.afterHooks(ctx, event, &mr)
+	return err
+}
 func (db *Db) QueryRow(sqlCase string, v ...interface{}) *sql.Row {
-	return db.db.QueryRow(sqlCase, v...)
+	ctx, event := db.beforeHooks(context.Background(), OpQueryRow, sqlCase, v, false)
+	row := db.reader().QueryRowContext(ctx, event.SQL, event.Args...)
+	mr := NewSqlResult(row.Err())
+	db.afterHooks(ctx, event, &mr)
+	return row
 }
 func (db *Db) Exec(sqlCase string, v ...interface{}) SqlResult {
 	var mr SqlResult
 	if db.db == nil {
 		mr.Code = "-1"
 		mr.Error = "数据库对象为 nil"
-		ju.OutputColor(errSkip, "red", mr.Error)
+		db.reportError(errSkip, errors.New(mr.Error))
 		return mr
 	}
-	rst, err := db.db.Exec(sqlCase, v...)
-	if ju.LogErrorTrace(err, errSkip) {
+	ctx, event := db.beforeHooks(context.Background(), OpExec, sqlCase, v, false)
+	rst, err := db.db.ExecContext(ctx, event.SQL, event.Args...)
+	if db.reportError(errSkip, err) {
 		mr.SetError(err)
 	} else {
 		mr.Result = rst
+		if count, e := rst.RowsAffected(); e == nil {
+			event.RowsAffected = count
+		}
 	}
+	db.afterHooks(ctx, event, &mr)
 	return mr
 }
+
+// exec 和 Exec 相同, 但是不输出错误
+func (db *Db) exec(sqlCase string, v ...interface{}) (sql.Result, error) {
+	if db.db == nil {
+		return nil, errors.New("数据库对象为 nil")
+	}
+	ctx, event := db.beforeHooks(context.Background(), OpExec, sqlCase, v, false)
+	rst, err := db.db.ExecContext(ctx, event.SQL, event.Args...)
+	mr := SqlResult{Result: rst}
+	mr.SetError(err)
+	if err == nil {
+		if count, e := rst.RowsAffected(); e == nil {
+			event.RowsAffected = count
+		}
+	}
+	db.afterHooks(ctx, event, &mr)
+	return rst, err
+}
+
+// Begin 开始事务. 返回的 sql.Tx 不经过 Hook, 需要 Hook 作用于事务中的语句时使用 BeginHooked
 func (db *Db) Begin() (*sql.Tx, SqlResult) {
-	var mr SqlResult
 	tx, err := db.db.Begin()
-	if ju.LogErrorTrace(err, errSkip) {
-		mr.SetError(err)
-		return nil, mr
+	if db.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return tx, SqlResult{}
+}
+
+// BeginHooked 开始事务, 返回的 Tx 用法和 sql.Tx 相同, Db 上注册的 Hook 同样作用于事务中的语句
+func (db *Db) BeginHooked() (*Tx, SqlResult) {
+	tx, err := db.begin()
+	if db.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return tx, SqlResult{}
+}
+
+// begin 和 BeginHooked 相同, 但是不输出错误
+func (db *Db) begin() (*Tx, error) {
+	ctx, event := db.beforeHooks(context.Background(), OpBegin, "", nil, true)
+	tx, err := db.db.BeginTx(ctx, nil)
+	mr := NewSqlResult(err)
+	db.afterHooks(ctx, event, &mr)
+	if err != nil {
+		return nil, err
 	}
-	return tx, mr
+	return &Tx{Tx: tx, db: db, ctx: ctx}, nil
 }
 func (db *Db) GetDb() *sql.DB {
 	return db.db
@@ -0,0 +1,187 @@
+package judb
+
+import (
+	"strings"
+)
+
+const (
+	statementBeginDirective = "-- +judb StatementBegin"
+	statementEndDirective   = "-- +judb StatementEnd"
+)
+
+// splitStatements 把脚本按分号拆分成单独的语句, 字符串, 引号包裹的标识符, 注释和 PostgreSQL 的 $tag$ 字符串中的分号
+// 不会拆分. CREATE TRIGGER 语句中 BEGIN 和 END 之间的分号也不会拆分. 其它包含分号的语句, 例如存储过程,
+// 使用 -- +judb StatementBegin 和 -- +judb StatementEnd 包围, 它们之间的内容作为一条语句.
+// 只包含注释的语句会被丢弃.
+func splitStatements(dbType, script string) []string {
+	var statements []string
+	var current strings.Builder
+	hasCode := false
+	// 当前语句的第一个关键字, 是否是 CREATE TRIGGER, 以及触发器中 BEGIN 和 CASE 的嵌套层数
+	first, trigger, depth := "", false, 0
+	flush := func() {
+		statement := strings.TrimSpace(current.String())
+		if hasCode && statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+		hasCode = false
+		first, trigger, depth = "", false, 0
+	}
+
+	n := len(script)
+	for i := 0; i < n; {
+		c := script[i]
+		switch {
+		case strings.HasPrefix(script[i:], statementBeginDirective):
+			flush()
+			start := i + len(statementBeginDirective)
+			end := strings.Index(script[start:], statementEndDirective)
+			if end < 0 {
+				end = n - start
+			}
+			current.WriteString(script[start : start+end])
+			hasCode = true
+			flush()
+			i = start + end + len(statementEndDirective)
+		case c == '-' && i+1 < n && script[i+1] == '-', c == '#' && dbType == DatabaseTypeMysql:
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = n - i
+			}
+			current.WriteString(script[i : i+end])
+			i += end
+		case c == '/' && i+1 < n && script[i+1] == '*':
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				end = n - i - 4
+			}
+			current.WriteString(script[i : i+end+4])
+			i += end + 4
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for j < n {
+				if script[j] == '\\' && dbType == DatabaseTypeMysql && j+1 < n {
+					j += 2
+					continue
+				}
+				if script[j] == c {
+					if j+1 < n && script[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j >= n {
+				j = n - 1
+			}
+			current.WriteString(script[i : j+1])
+			hasCode = true
+			i = j + 1
+		case c == '$' && dbType == DatabaseTypePostgres && dollarTag(script[i:]) != "":
+			tag := dollarTag(script[i:])
+			end := strings.Index(script[i+len(tag):], tag)
+			if end < 0 {
+				end = n - i - 2*len(tag)
+			}
+			current.WriteString(script[i : i+2*len(tag)+end])
+			hasCode = true
+			i += 2*len(tag) + end
+		case c == ';':
+			if trigger && depth > 0 {
+				current.WriteByte(c)
+			} else {
+				flush()
+			}
+			i++
+		case isWordStart(c) && (i == 0 || !isWordChar(script[i-1]) && script[i-1] != '.'):
+			word := readWord(script, i)
+			current.WriteString(word)
+			hasCode = true
+			i += len(word)
+			upper := strings.ToUpper(word)
+			if first == "" {
+				first = upper
+			}
+			switch {
+			case first == "CREATE" && upper == "TRIGGER" && depth == 0:
+				trigger = true
+			case !trigger:
+			case upper == "BEGIN" || upper == "CASE":
+				depth++
+			case upper == "END" && depth > 0:
+				// MySQL 的 END IF, END LOOP 等结束的块没有计入层数, END CASE 结束的是 CASE
+				next := nextWord(script, i)
+				switch strings.ToUpper(next) {
+				case "IF", "LOOP", "WHILE", "REPEAT":
+				default:
+					depth--
+				}
+				if next != "" {
+					end := strings.Index(script[i:], next) + len(next)
+					current.WriteString(script[i : i+end])
+					i += end
+				}
+			}
+		default:
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				hasCode = true
+			}
+			current.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return statements
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isWordChar(c byte) bool {
+	return isWordStart(c) || c >= '0' && c <= '9' || c == '$'
+}
+
+// readWord 返回 s[i:] 开头的标识符或关键字
+func readWord(s string, i int) string {
+	j := i
+	for j < len(s) && isWordChar(s[j]) {
+		j++
+	}
+	return s[i:j]
+}
+
+// nextWord 跳过空白后, 如果紧接着的是 IF, LOOP, WHILE, REPEAT 或 CASE, 返回这个词, 否则返回空串
+func nextWord(s string, i int) string {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i++
+	}
+	if i >= len(s) || !isWordStart(s[i]) {
+		return ""
+	}
+	word := readWord(s, i)
+	switch strings.ToUpper(word) {
+	case "IF", "LOOP", "WHILE", "REPEAT", "CASE":
+		return word
+	}
+	return ""
+}
+
+// dollarTag 如果 s 以 $tag$ 或 $$ 开头, 返回这个标记, 否则返回空串
+func dollarTag(s string) string {
+	end := strings.IndexByte(s[1:], '$')
+	if end < 0 {
+		return ""
+	}
+	tag := s[1 : end+1]
+	for i := 0; i < len(tag); i++ {
+		c := tag[i]
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return s[:end+2]
+}
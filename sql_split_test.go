@@ -0,0 +1,99 @@
+package judb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		dbType string
+		script string
+		want   []string
+	}{
+		{
+			name:   "simple",
+			dbType: DatabaseTypeSqlite,
+			script: "CREATE TABLE a (id INTEGER);\n\nINSERT INTO a VALUES (1);INSERT INTO a VALUES (2)\n",
+			want:   []string{"CREATE TABLE a (id INTEGER)", "INSERT INTO a VALUES (1)", "INSERT INTO a VALUES (2)"},
+		},
+		{
+			name:   "quotes",
+			dbType: DatabaseTypeSqlite,
+			script: `INSERT INTO a VALUES ('x;y', 'it''s;'); SELECT "a;b" FROM "t;1"`,
+			want:   []string{`INSERT INTO a VALUES ('x;y', 'it''s;')`, `SELECT "a;b" FROM "t;1"`},
+		},
+		{
+			name:   "mysql backslash and backtick",
+			dbType: DatabaseTypeMysql,
+			script: "INSERT INTO `a;b` VALUES ('x\\';y'); # comment; here\nSELECT 1",
+			want:   []string{"INSERT INTO `a;b` VALUES ('x\\';y')", "# comment; here\nSELECT 1"},
+		},
+		{
+			name:   "comments",
+			dbType: DatabaseTypeSqlite,
+			script: "-- only a comment;\n/* block; comment */\nSELECT 1; -- trailing\n",
+			want:   []string{"-- only a comment;\n/* block; comment */\nSELECT 1"},
+		},
+		{
+			name:   "dollar quoting",
+			dbType: DatabaseTypePostgres,
+			script: "CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql;\n" +
+				"CREATE FUNCTION g() RETURNS text AS $body$ SELECT 'a;$$;b'; $body$ LANGUAGE sql;\nSELECT $1",
+			want: []string{
+				"CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql",
+				"CREATE FUNCTION g() RETURNS text AS $body$ SELECT 'a;$$;b'; $body$ LANGUAGE sql",
+				"SELECT $1",
+			},
+		},
+		{
+			name:   "postgres transaction keywords",
+			dbType: DatabaseTypePostgres,
+			script: "BEGIN; UPDATE a SET v = 1; END;",
+			want:   []string{"BEGIN", "UPDATE a SET v = 1", "END"},
+		},
+		{
+			name:   "sqlite trigger",
+			dbType: DatabaseTypeSqlite,
+			script: "CREATE TRIGGER a_ai AFTER INSERT ON a BEGIN\n" +
+				"  INSERT INTO log VALUES (new.id, CASE WHEN new.v > 0 THEN 'pos;' ELSE 'neg' END);\n" +
+				"  UPDATE a SET n = new.end WHERE id = new.id;\n" +
+				"END;\nINSERT INTO a VALUES (1);",
+			want: []string{
+				"CREATE TRIGGER a_ai AFTER INSERT ON a BEGIN\n" +
+					"  INSERT INTO log VALUES (new.id, CASE WHEN new.v > 0 THEN 'pos;' ELSE 'neg' END);\n" +
+					"  UPDATE a SET n = new.end WHERE id = new.id;\nEND",
+				"INSERT INTO a VALUES (1)",
+			},
+		},
+		{
+			name:   "mysql trigger",
+			dbType: DatabaseTypeMysql,
+			script: "CREATE DEFINER = CURRENT_USER TRIGGER a_bi BEFORE INSERT ON a FOR EACH ROW BEGIN\n" +
+				"  IF new.v < 0 THEN SET new.v = 0; END IF;\n" +
+				"  CASE new.k WHEN 1 THEN SET new.s = 'one'; ELSE SET new.s = 'other'; END CASE;\n" +
+				"END;\nSELECT 1",
+			want: []string{
+				"CREATE DEFINER = CURRENT_USER TRIGGER a_bi BEFORE INSERT ON a FOR EACH ROW BEGIN\n" +
+					"  IF new.v < 0 THEN SET new.v = 0; END IF;\n" +
+					"  CASE new.k WHEN 1 THEN SET new.s = 'one'; ELSE SET new.s = 'other'; END CASE;\nEND",
+				"SELECT 1",
+			},
+		},
+		{
+			name:   "directives",
+			dbType: DatabaseTypeMysql,
+			script: "-- +judb StatementBegin\nCREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END\n-- +judb StatementEnd\nSELECT 3;",
+			want:   []string{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "SELECT 3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.dbType, tt.script)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitStatements =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
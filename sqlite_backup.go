@@ -0,0 +1,329 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DefaultBackupPagesPerStep BackupOptions.PagesPerStep 没有设置时每一步复制的页数
+const DefaultBackupPagesPerStep = 256
+
+// BackupOptions SQLite 在线备份的设置. 备份分步进行, 每一步之间其它连接可以读写数据库,
+// 源数据库在备份过程中被其它连接修改时, 备份会自动重新开始.
+type BackupOptions struct {
+	PagesPerStep int // 每一步复制的页数, 默认 DefaultBackupPagesPerStep, -1 表示一步完成
+	// Progress 每一步之后调用, remaining 是剩余的页数, total 是总页数
+	Progress func(remaining, total int)
+	// Key 文件是加密的数据库时的密钥, 需要链接 SQLCipher 并使用 sqlcipher 标签构建. 加密的文件不能使用备份 API,
+	// 加载时逐表复制, 保存时使用 sqlcipher_export, 这时 Progress 只在完成时调用一次.
+	Key string
+}
+
+// LoadFromFileContext 通过 SQLite 的在线备份 API 把文件数据库加载到内存数据库, 内存数据库中原有的内容会被替换.
+// ctx 取消时停止加载并返回 ctx.Err(), 内存数据库保持原来的内容.
+//
+// 内存数据库的页大小创建后不能修改, 文件数据库的页大小和内存数据库不同时, 改为逐表复制的方式加载, 这时 Progress 只在完成时调用一次.
+func (mdb *SQLiteMemDb) LoadFromFileContext(ctx context.Context, fileDB string, options BackupOptions) error {
+	if !mdb.Open() {
+		return errors.New("打开内存数据库失败")
+	}
+	if _, err := os.Stat(fileDB); err != nil {
+		return err
+	}
+	if options.Key != "" {
+		if !sqlcipherCompiled {
+			return ErrSqlcipherNotCompiled
+		}
+		err := mdb.write(ctx, func(conn *sql.Conn) error {
+			return loadSqliteFile(ctx, conn, fileDB, options.Key)
+		})
+		if err == nil && options.Progress != nil {
+			options.Progress(0, 0)
+		}
+		return sqliteEncryptedError(err, options.Key)
+	}
+	fdb, err := sql.Open("sqlite3", sqliteFileURI(fileDB, "mode=ro"))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fdb.Close()
+	}()
+	// 驱动建立连接时会读取数据库, 文件不是数据库时在这里返回错误
+	fileConn, err := fdb.Conn(ctx)
+	if err != nil {
+		return sqliteEncryptedError(err, "")
+	}
+	defer func() {
+		_ = fileConn.Close()
+	}()
+	// 加载是写操作, 在写入连接上执行
+	err = mdb.write(ctx, func(conn *sql.Conn) error {
+		err := sqliteBackup(ctx, conn, fileConn, options)
+		var sqErr sqlite3.Error
+		if errors.As(err, &sqErr) && sqErr.Code == sqlite3.ErrReadonly {
+			if err = loadSqliteFile(ctx, conn, fileDB, ""); err == nil && options.Progress != nil {
+				options.Progress(0, 0)
+			}
+		}
+		return err
+	})
+	return sqliteEncryptedError(err, "")
+}
+
+// SaveToFileContext 通过 SQLite 的在线备份 API 把内存数据库保存到文件, 文件存在时会被覆盖.
+// 数据先写到同一目录下的临时文件, 完成后再改名为 fileDB, 中途失败或者 ctx 取消不会留下不完整的文件.
+// 覆盖的文件保留原来的权限, 新文件的权限是 0644 去掉 umask 的部分.
+//
+// 备份的每一步在写队列中执行, 两步之间其它的写操作可以执行, 保存期间可以读取. 保存期间提交的写入, 包括直接通过 Db
+// 的写入, 会使备份从头开始, 所以保存的是一致的数据, 重新开始 3 次后剩下的部分在一次写操作中完成. 直接通过 Db 写入时,
+// 备份的一步可能读到还没有提交的修改, 这个事务回滚时保存的文件会包含回滚的修改, 所以保存期间需要通过写队列写入.
+// 使用 Key 保存加密的文件时, 整个导出在一次写操作中完成, 期间写操作等待保存完成.
+func (mdb *SQLiteMemDb) SaveToFileContext(ctx context.Context, fileDB string, options BackupOptions) error {
+	if mdb.Db == nil {
+		return errors.New("内存数据库没有打开")
+	}
+	if options.Key != "" && !sqlcipherCompiled {
+		return ErrSqlcipherNotCompiled
+	}
+	tmpPath, err := createTempFile(fileDB)
+	if err != nil {
+		return err
+	}
+	saved := false
+	defer func() {
+		if !saved {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if options.Key != "" {
+		err = mdb.write(ctx, func(conn *sql.Conn) error {
+			return exportEncrypted(ctx, conn, tmpPath, options.Key)
+		})
+		if err == nil && options.Progress != nil {
+			options.Progress(0, 0)
+		}
+	} else {
+		var fdb *sql.DB
+		if fdb, err = sql.Open("sqlite3", sqliteFileURI(tmpPath, "")); err != nil {
+			return err
+		}
+		err = mdb.saveSqliteFile(ctx, fdb, options)
+		if cerr := fdb.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err = syncFile(tmpPath); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, fileDB); err != nil {
+		return err
+	}
+	saved = true
+	return nil
+}
+
+// backupMaxRestarts 保存时备份因为写入重新开始的次数超过这个值后, 剩下的部分在一次写操作中完成
+const backupMaxRestarts = 3
+
+// saveSqliteFile 把内存数据库备份到 fdb. 备份的源是写入连接, 每一步作为一个写操作放入写队列, 两步之间其它的写操作可以执行.
+// 提交的写入会使备份从头开始, 所以保存的是一致的数据. 写入频繁导致重新开始 backupMaxRestarts 次后,
+// 剩下的页在一次写操作中复制, 保证保存可以完成.
+func (mdb *SQLiteMemDb) saveSqliteFile(ctx context.Context, fdb *sql.DB, options BackupOptions) error {
+	pages := options.PagesPerStep
+	if pages == 0 {
+		pages = DefaultBackupPagesPerStep
+	}
+	fileConn, err := fdb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fileConn.Close()
+	}()
+	return fileConn.Raw(func(destDriver any) error {
+		d, ok := destDriver.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("备份只支持 sqlite3 驱动的连接")
+		}
+		var backup *sqlite3.SQLiteBackup
+		// 备份对象引用写入连接, 只能在写队列中使用
+		defer func() {
+			if backup != nil {
+				_ = mdb.write(context.Background(), func(conn *sql.Conn) error {
+					return backup.Finish()
+				})
+			}
+		}()
+		lastRemaining, restarts := -1, 0
+		for {
+			step := pages
+			if restarts >= backupMaxRestarts {
+				step = -1
+			}
+			var done bool
+			var remaining, total int
+			err := mdb.write(ctx, func(conn *sql.Conn) error {
+				return conn.Raw(func(srcDriver any) error {
+					if backup == nil {
+						s, ok := srcDriver.(*sqlite3.SQLiteConn)
+						if !ok {
+							return fmt.Errorf("备份只支持 sqlite3 驱动的连接")
+						}
+						var err error
+						if backup, err = d.Backup("main", s, "main"); err != nil {
+							return err
+						}
+					}
+					var err error
+					if done, err = backup.Step(step); err != nil {
+						return err
+					}
+					remaining, total = backup.Remaining(), backup.PageCount()
+					if done {
+						err = backup.Finish()
+						backup = nil
+					}
+					return err
+				})
+			})
+			if err != nil {
+				return err
+			}
+			if options.Progress != nil {
+				options.Progress(remaining, total)
+			}
+			if done {
+				return nil
+			}
+			if lastRemaining >= 0 && remaining > lastRemaining {
+				restarts++
+			}
+			// 数据库被锁定时 Step 不会复制任何页, 在写队列之外等待一会再重试
+			if remaining == lastRemaining {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			lastRemaining = remaining
+		}
+	})
+}
+
+// sqliteBackup 把 src 连接的 main 数据库复制到 dest 连接的 main 数据库
+func sqliteBackup(ctx context.Context, destConn, srcConn *sql.Conn, options BackupOptions) error {
+	pages := options.PagesPerStep
+	if pages == 0 {
+		pages = DefaultBackupPagesPerStep
+	}
+	return destConn.Raw(func(destDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			d, ok1 := destDriver.(*sqlite3.SQLiteConn)
+			s, ok2 := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return fmt.Errorf("备份只支持 sqlite3 驱动的连接")
+			}
+			backup, err := d.Backup("main", s, "main")
+			if err != nil {
+				return err
+			}
+			// Finish 可以重复调用, 出错返回时确保释放备份对象
+			defer func() {
+				_ = backup.Finish()
+			}()
+			lastRemaining := -1
+			for {
+				if err = ctx.Err(); err != nil {
+					return err
+				}
+				done, err := backup.Step(pages)
+				if err != nil {
+					return err
+				}
+				remaining := backup.Remaining()
+				if options.Progress != nil {
+					options.Progress(remaining, backup.PageCount())
+				}
+				if done {
+					return backup.Finish()
+				}
+				// 数据库被锁定时 Step 不会复制任何页, 等待一会再重试
+				if remaining == lastRemaining {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(10 * time.Millisecond):
+					}
+				}
+				lastRemaining = remaining
+			}
+		})
+	})
+}
+
+// sqliteFileURI 把文件路径转换为 SQLite 的 URI 文件名, 路径中的 ? 和 # 等字符会被转义
+func sqliteFileURI(path, query string) string {
+	uri := "file:" + (&url.URL{Path: path}).EscapedPath()
+	if query != "" {
+		uri += "?" + query
+	}
+	return uri
+}
+
+// createTempFile 在 fileDB 所在的目录创建一个空的临时文件, 返回它的路径. fileDB 存在时临时文件使用 fileDB 的权限,
+// 否则和 os.Create 一样使用 0644 去掉 umask 的权限. os.CreateTemp 创建的文件权限是 0600, 改名后会替换原来的权限
+func createTempFile(fileDB string) (string, error) {
+	perm := os.FileMode(0644)
+	info, err := os.Stat(fileDB)
+	if err == nil {
+		perm = info.Mode().Perm()
+	}
+	for try := 0; ; try++ {
+		path := fmt.Sprintf("%s.%d.tmp", fileDB, rand.Uint32())
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info != nil {
+			// OpenFile 的权限会去掉 umask 的部分, 需要再设置一次
+			err = f.Chmod(perm)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(path)
+			return "", err
+		}
+		return path, nil
+	}
+}
+
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
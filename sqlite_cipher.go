@@ -0,0 +1,142 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrSqlcipherNotCompiled 设置了密钥, 但是构建时没有使用 sqlcipher 标签
+var ErrSqlcipherNotCompiled = errors.New(`sqlite: 没有编译加密支持, 需要链接 SQLCipher 并使用 -tags "sqlcipher libsqlite3" 构建`)
+
+// sqliteCipher 保存加密数据库当前的密钥, Rekey 之后新建的连接使用新的密钥
+type sqliteCipher struct {
+	mu      sync.RWMutex
+	key     string
+	maxIdle int // 打开时设置的最大空闲连接数, Rekey 之后恢复
+}
+
+func (c *sqliteCipher) get() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.key
+}
+
+// Rekey 修改加密数据库的密钥, 数据库需要由 OpenSqlite3Options 设置 Key 打开. 修改后连接池中的空闲连接会被关闭,
+// 最大空闲连接数恢复为 SqliteOptions.MaxIdleConns, 之后新建的连接使用新的密钥.
+//
+// 使用旧密钥的连接在修改后不能再读取数据库. 设置了 ReadConns 时, Rekey 取得写连接和只读连接池的全部连接,
+// 等待正在进行的读写完成后再修改, 所以不能在事务中, 或者遍历 Query 的结果时调用. 没有设置 ReadConns 时连接池没有上限,
+// 不能等待所有的连接, 调用期间不要有其它正在使用的连接.
+func (db *Db) Rekey(newKey string) SqlResult {
+	err := db.rekey(newKey)
+	if db.reportError(errSkip, err) {
+		return NewSqlResult(err)
+	}
+	return SqlResult{}
+}
+
+func (db *Db) rekey(newKey string) error {
+	if !sqlcipherCompiled {
+		return ErrSqlcipherNotCompiled
+	}
+	c := db.cipher
+	if c == nil {
+		return errors.New("sqlite: 数据库没有设置 Key")
+	}
+	if newKey == "" {
+		return errors.New("sqlite: 新的密钥不能为空")
+	}
+	ctx := context.Background()
+	pools := []*sql.DB{db.db}
+	if db.readDb != nil {
+		pools = append(pools, db.readDb)
+	}
+	// 先取得连接, 新建连接时 ConnectHook 需要读取密钥
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	held := []*sql.Conn{conn}
+	defer func() {
+		// 先关闭空闲连接并禁止保留空闲连接, 取得的连接使用旧的密钥, 归还时直接关闭
+		for _, pool := range pools {
+			pool.SetMaxIdleConns(0)
+		}
+		for _, hc := range held {
+			_ = hc.Close()
+		}
+		for _, pool := range pools {
+			pool.SetMaxIdleConns(c.maxIdle)
+		}
+	}()
+	if db.readDb != nil {
+		// 只读连接池有上限, 取得全部连接, 等待正在进行的读取完成
+		for i := 0; i < db.readDb.Stats().MaxOpenConnections; i++ {
+			rc, err := db.readDb.Conn(ctx)
+			if err != nil {
+				return err
+			}
+			held = append(held, rc)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err = conn.ExecContext(ctx, "PRAGMA rekey = "+sqliteString(newKey)); err != nil {
+		return err
+	}
+	c.key = newKey
+	return nil
+}
+
+// checkSqlcipher 检查链接的 SQLite 是否是 SQLCipher, 普通的 SQLite 会忽略 PRAGMA key, 数据以明文保存
+func checkSqlcipher(ctx context.Context, conn *sql.Conn) error {
+	if !sqlcipherCompiled {
+		return ErrSqlcipherNotCompiled
+	}
+	var version string
+	err := conn.QueryRowContext(ctx, "PRAGMA cipher_version").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && version == "" {
+		return errors.New("sqlite: 使用了 sqlcipher 标签, 但是链接的 SQLite 不是 SQLCipher")
+	}
+	return err
+}
+
+// sqliteEncryptedError 文件是加密的数据库, 或者密钥错误时, SQLite 只返回 file is not a database, 这里补充可能的原因
+func sqliteEncryptedError(err error, key string) error {
+	var sqErr sqlite3.Error
+	if !errors.As(err, &sqErr) || sqErr.Code != sqlite3.ErrNotADB {
+		return err
+	}
+	switch {
+	case key != "":
+		return fmt.Errorf("sqlite: 密钥错误或者文件不是 SQLite 数据库: %w", err)
+	case !sqlcipherCompiled:
+		return fmt.Errorf("sqlite: 文件不是 SQLite 数据库, 如果是加密的数据库, 需要设置密钥并使用 sqlcipher 标签构建: %w", err)
+	}
+	return fmt.Errorf("sqlite: 文件不是 SQLite 数据库, 如果是加密的数据库, 需要设置密钥: %w", err)
+}
+
+// exportEncrypted 在 conn 上把 main 数据库导出到加密的文件 fileDB, 文件需要不存在或者是空文件
+func exportEncrypted(ctx context.Context, conn *sql.Conn, fileDB, key string) error {
+	if err := checkSqlcipher(ctx, conn); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS judb_dst KEY ?", fileDB, key); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, "SELECT sqlcipher_export('judb_dst')")
+	if _, derr := conn.ExecContext(context.Background(), "DETACH DATABASE judb_dst"); err == nil {
+		err = derr
+	}
+	return err
+}
+
+func sqliteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,5 @@
+//go:build !sqlcipher
+
+package judb
+
+const sqlcipherCompiled = false
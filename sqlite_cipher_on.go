@@ -0,0 +1,7 @@
+//go:build sqlcipher
+
+package judb
+
+// sqlcipherCompiled 使用 sqlcipher 标签构建, 同时需要链接 SQLCipher 编译的 libsqlite3, 例如
+// CGO_CFLAGS="-DSQLITE_HAS_CODEC" go build -tags "sqlcipher libsqlite3"
+const sqlcipherCompiled = true
@@ -0,0 +1,90 @@
+//go:build !sqlcipher
+
+package judb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestSqlcipherNotCompiled(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "plain.db")
+	var plain Db
+	if !plain.OpenSqlite3(file, "") {
+		t.Fatal("open sqlite failed")
+	}
+	if mr := plain.Exec("CREATE TABLE t (v TEXT)"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	plain.Close()
+
+	var db Db
+	reporter := &recordReporter{}
+	db.SetErrorReporter(reporter)
+	opts := NewSqliteOptions()
+	opts.Key = "secret"
+	if db.OpenSqlite3Options(filepath.Join(dir, "enc.db"), opts) {
+		t.Fatal("OpenSqlite3Options with Key succeeded")
+	}
+	if !errors.Is(reporter.err, ErrSqlcipherNotCompiled) {
+		t.Fatalf("OpenSqlite3Options reported %v, want ErrSqlcipherNotCompiled", reporter.err)
+	}
+
+	var mdb SQLiteMemDb
+	if !mdb.Open() {
+		t.Fatal("open memory database failed")
+	}
+	defer mdb.Close()
+	ctx := context.Background()
+	if err := mdb.LoadFromFileContext(ctx, file, BackupOptions{Key: "secret"}); !errors.Is(err, ErrSqlcipherNotCompiled) {
+		t.Fatalf("LoadFromFileContext = %v, want ErrSqlcipherNotCompiled", err)
+	}
+	out := filepath.Join(dir, "out.db")
+	if err := mdb.SaveToFileContext(ctx, out, BackupOptions{Key: "secret"}); !errors.Is(err, ErrSqlcipherNotCompiled) {
+		t.Fatalf("SaveToFileContext = %v, want ErrSqlcipherNotCompiled", err)
+	}
+	if matches, _ := filepath.Glob(out + "*"); len(matches) != 0 {
+		t.Fatalf("SaveToFileContext left files %v", matches)
+	}
+}
+
+func TestOpenSqlite3NotADatabase(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "garbage.db")
+	if err := os.WriteFile(file, bytes.Repeat([]byte("not a database "), 512), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var db Db
+	reporter := &recordReporter{}
+	db.SetErrorReporter(reporter)
+	if db.OpenSqlite3(file, "") {
+		t.Fatal("OpenSqlite3 opened a file that is not a database")
+	}
+	var sqErr sqlite3.Error
+	if !errors.As(reporter.err, &sqErr) || sqErr.Code != sqlite3.ErrNotADB || !strings.Contains(reporter.err.Error(), "sqlcipher") {
+		t.Fatalf("OpenSqlite3 reported %v", reporter.err)
+	}
+	if db.db != nil {
+		t.Fatal("OpenSqlite3 kept the pool after failing")
+	}
+	// 参数中没有 journal_mode 时也在打开时检查
+	if db.OpenSqlite3(file, "_mutex=full") {
+		t.Fatal("OpenSqlite3 without journal_mode opened a file that is not a database")
+	}
+}
+
+// recordReporter 记录最后一次输出的错误
+type recordReporter struct {
+	err error
+}
+
+func (r *recordReporter) Report(_ int, err error) {
+	r.err = err
+}
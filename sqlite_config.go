@@ -0,0 +1,313 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SqliteOptions SQLite 文件数据库的参数. 建议使用 NewSqliteOptions 创建, 它会设置推荐的默认值,
+// 然后通过 OpenSqlite3Options 打开. 字符串类型的参数为空时使用 SQLite 的默认值.
+type SqliteOptions struct {
+	JournalMode string        // DELETE, TRUNCATE, PERSIST, MEMORY, WAL 或 OFF, 只读时不设置
+	Synchronous string        // OFF, NORMAL, FULL 或 EXTRA
+	BusyTimeout time.Duration // 数据库被其它连接锁定时的等待时间, 0 表示不等待
+	ForeignKeys bool          // 是否检查外键约束
+	CacheSize   int           // 页缓存大小, 正数是页数, 负数是 KiB, 0 表示默认值
+	MmapSize    int64         // 内存映射的字节数, 0 表示不使用, 不能超过编译时的上限 SQLITE_MAX_MMAP_SIZE
+	TempStore   string        // 临时表和索引的位置, DEFAULT, FILE 或 MEMORY
+
+	// Key 加密数据库的密钥, 为空时不加密. 需要链接 SQLCipher 并使用 sqlcipher 标签构建, 否则打开时返回
+	// ErrSqlcipherNotCompiled. 新建的数据库使用这个密钥加密, 已有的明文数据库不能通过设置 Key 加密.
+	Key string
+
+	ReadOnly bool // 只读打开, 文件必须存在
+	// Immutable 声明文件在打开期间不会被任何进程修改, SQLite 不再加锁和检查修改, 隐含 ReadOnly.
+	// 文件实际被修改时会读到错误的数据.
+	Immutable bool
+
+	// ReadConns 大于 0 时打开单独的只读连接池, 最多 ReadConns 个连接, Query 和 QueryRow 使用只读连接池,
+	// 所以不能用它们执行带 RETURNING 的写语句. 其它操作使用只有一个连接的写连接池, 写事务使用 BEGIN IMMEDIATE.
+	// 这时在事务中不能调用 Db.Exec, 它会一直等待事务占用的连接. 通常和 WAL 一起使用, 读取不会被写入阻塞.
+	ReadConns int
+	// MaxIdleConns 每个连接池的最大空闲连接数, 0 使用 database/sql 的默认值 2. Rekey 关闭空闲连接后恢复为这个值,
+	// 所以需要修改时在这里设置, 而不是通过 GetDb 修改
+	MaxIdleConns int
+}
+
+// NewSqliteOptions 创建 SQLite 参数, 默认使用 WAL, synchronous 为 NORMAL, 等待锁 5 秒, 检查外键
+func NewSqliteOptions() *SqliteOptions {
+	return &SqliteOptions{
+		JournalMode: "WAL",
+		Synchronous: "NORMAL",
+		BusyTimeout: 5 * time.Second,
+		ForeignKeys: true,
+	}
+}
+
+var (
+	sqliteJournalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	sqliteSynchronous  = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+	sqliteTempStores   = []string{"DEFAULT", "FILE", "MEMORY"}
+)
+
+// Validate 检查参数是否有效
+func (opts *SqliteOptions) Validate() error {
+	if opts.JournalMode != "" && sqliteEnum(sqliteJournalModes, opts.JournalMode) < 0 {
+		return fmt.Errorf("sqlite: 无效的 JournalMode %q", opts.JournalMode)
+	}
+	if opts.Synchronous != "" && sqliteEnum(sqliteSynchronous, opts.Synchronous) < 0 {
+		return fmt.Errorf("sqlite: 无效的 Synchronous %q", opts.Synchronous)
+	}
+	if opts.TempStore != "" && sqliteEnum(sqliteTempStores, opts.TempStore) < 0 {
+		return fmt.Errorf("sqlite: 无效的 TempStore %q", opts.TempStore)
+	}
+	if opts.BusyTimeout < 0 {
+		return errors.New("sqlite: BusyTimeout 不能是负数")
+	}
+	if opts.MmapSize < 0 {
+		return errors.New("sqlite: MmapSize 不能是负数")
+	}
+	if opts.ReadConns < 0 {
+		return errors.New("sqlite: ReadConns 不能是负数")
+	}
+	if opts.MaxIdleConns < 0 {
+		return errors.New("sqlite: MaxIdleConns 不能是负数")
+	}
+	return nil
+}
+
+func (opts *SqliteOptions) readOnly() bool {
+	return opts.ReadOnly || opts.Immutable
+}
+
+// FormatDSN 生成 go-sqlite3 使用的 DSN, 路径中的特殊字符会被转义. MmapSize, TempStore 和 Key 没有对应的 DSN 参数,
+// 由 OpenSqlite3Options 在每个连接建立时设置, 设置了 Key 时 JournalMode 也在设置密钥之后设置. 参数无效时返回空串
+func (opts *SqliteOptions) FormatDSN(path string) string {
+	if opts.Validate() != nil {
+		return ""
+	}
+	return opts.dsn(path, false)
+}
+
+// dsn reader 为 true 时生成只读连接池的 DSN
+func (opts *SqliteOptions) dsn(path string, reader bool) string {
+	params := url.Values{}
+	params.Set("_mutex", "full")
+	// 设置 journal_mode 需要读取数据库, 加密的数据库要在设置密钥之后
+	if opts.JournalMode != "" && !opts.readOnly() && opts.Key == "" {
+		params.Set("_journal_mode", strings.ToUpper(opts.JournalMode))
+	}
+	if opts.Synchronous != "" {
+		params.Set("_synchronous", strings.ToUpper(opts.Synchronous))
+	}
+	params.Set("_busy_timeout", strconv.FormatInt(opts.BusyTimeout.Milliseconds(), 10))
+	if opts.ForeignKeys {
+		params.Set("_foreign_keys", "1")
+	} else {
+		params.Set("_foreign_keys", "0")
+	}
+	if opts.CacheSize != 0 {
+		params.Set("_cache_size", strconv.Itoa(opts.CacheSize))
+	}
+	if opts.ReadOnly {
+		params.Set("mode", "ro")
+	}
+	if opts.Immutable {
+		params.Set("immutable", "1")
+	}
+	if reader {
+		params.Set("_query_only", "1")
+	} else if opts.ReadConns > 0 && !opts.readOnly() {
+		params.Set("_txlock", "immediate")
+	}
+	return sqliteFileURI(path, params.Encode())
+}
+
+// connectHook 设置 DSN 不支持的参数并注册 funcs, 加密的数据库先设置密钥
+func (opts *SqliteOptions) connectHook(conn *sqlite3.SQLiteConn, cipher *sqliteCipher, funcs *SqliteFunctions) error {
+	if cipher != nil {
+		if _, err := conn.Exec("PRAGMA key = "+sqliteString(cipher.get()), nil); err != nil {
+			return err
+		}
+		if opts.JournalMode != "" && !opts.readOnly() {
+			if _, err := conn.Exec("PRAGMA journal_mode = "+strings.ToUpper(opts.JournalMode), nil); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.MmapSize > 0 {
+		if _, err := conn.Exec(fmt.Sprintf("PRAGMA mmap_size = %d", opts.MmapSize), nil); err != nil {
+			return err
+		}
+	}
+	if opts.TempStore != "" {
+		if _, err := conn.Exec("PRAGMA temp_store = "+strings.ToUpper(opts.TempStore), nil); err != nil {
+			return err
+		}
+	}
+	if funcs != nil {
+		return funcs.register(conn)
+	}
+	return nil
+}
+
+// sqliteConnector 使用自己的 SQLiteDriver 建立连接, 不需要注册全局的驱动名称
+type sqliteConnector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+}
+
+func (c *sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// OpenSqlite3Options 按 opts 打开 SQLite 文件数据库, opts 为 nil 时使用 NewSqliteOptions 的默认值.
+// 打开后会建立连接并读取各项 PRAGMA 的实际值, 和设置的值不一致时关闭数据库并返回 false,
+// 例如只读的数据库不能切换到 WAL, 或者 MmapSize 超过了编译时的上限.
+func (db *Db) OpenSqlite3Options(path string, opts *SqliteOptions) bool {
+	if db.db != nil {
+		return true
+	}
+	if opts == nil {
+		opts = NewSqliteOptions()
+	}
+	err := db.openSqlite3(path, opts)
+	return !db.reportError(errSkip, err)
+}
+
+func (db *Db) openSqlite3(path string, opts *SqliteOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	// 复制一份, 打开后调用者修改 opts 不影响新建的连接
+	o := *opts
+	opts = &o
+	var cipher *sqliteCipher
+	if opts.Key != "" {
+		if !sqlcipherCompiled {
+			return ErrSqlcipherNotCompiled
+		}
+		cipher = &sqliteCipher{key: opts.Key, maxIdle: opts.maxIdleConns()}
+	}
+	funcs := db.sqliteFuncs
+	drv := &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		return opts.connectHook(conn, cipher, funcs)
+	}}
+	writer := sql.OpenDB(&sqliteConnector{driver: drv, dsn: opts.dsn(path, false)})
+	var reader *sql.DB
+	if opts.ReadConns > 0 {
+		writer.SetMaxOpenConns(1)
+		reader = sql.OpenDB(&sqliteConnector{driver: drv, dsn: opts.dsn(path, true)})
+		reader.SetMaxOpenConns(opts.ReadConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		writer.SetMaxIdleConns(opts.MaxIdleConns)
+		if reader != nil {
+			reader.SetMaxIdleConns(opts.MaxIdleConns)
+		}
+	}
+	err := verifySqlitePragmas(writer, opts, false)
+	if err == nil && reader != nil {
+		err = verifySqlitePragmas(reader, opts, true)
+	}
+	if err != nil {
+		_ = writer.Close()
+		if reader != nil {
+			_ = reader.Close()
+		}
+		return sqliteEncryptedError(err, opts.Key)
+	}
+	db.db = writer
+	db.readDb = reader
+	db.cipher = cipher
+	db.dbType = DatabaseTypeSqlite
+	return nil
+}
+
+// maxIdleConns 返回连接池实际的最大空闲连接数
+func (opts *SqliteOptions) maxIdleConns() int {
+	if opts.MaxIdleConns > 0 {
+		return opts.MaxIdleConns
+	}
+	// database/sql 的默认值
+	return 2
+}
+
+// verifySqlitePragmas 在连接池的一个连接上检查 PRAGMA 的实际值, 所有连接使用相同的参数, 一个连接正确就说明参数生效了
+func verifySqlitePragmas(pool *sql.DB, opts *SqliteOptions, reader bool) error {
+	ctx := context.Background()
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if opts.Key != "" {
+		if err = checkSqlcipher(ctx, conn); err != nil {
+			return err
+		}
+	}
+	type pragmaValue struct {
+		name string
+		want interface{}
+	}
+	var pragmas []pragmaValue
+	if opts.JournalMode != "" && !opts.readOnly() {
+		pragmas = append(pragmas, pragmaValue{"journal_mode", opts.JournalMode})
+	}
+	if opts.Synchronous != "" {
+		pragmas = append(pragmas, pragmaValue{"synchronous", sqliteEnum(sqliteSynchronous, opts.Synchronous)})
+	}
+	pragmas = append(pragmas, pragmaValue{"busy_timeout", opts.BusyTimeout.Milliseconds()})
+	if opts.ForeignKeys {
+		pragmas = append(pragmas, pragmaValue{"foreign_keys", 1})
+	} else {
+		pragmas = append(pragmas, pragmaValue{"foreign_keys", 0})
+	}
+	if opts.CacheSize != 0 {
+		pragmas = append(pragmas, pragmaValue{"cache_size", opts.CacheSize})
+	}
+	if opts.MmapSize > 0 {
+		pragmas = append(pragmas, pragmaValue{"mmap_size", opts.MmapSize})
+	}
+	if opts.TempStore != "" {
+		pragmas = append(pragmas, pragmaValue{"temp_store", sqliteEnum(sqliteTempStores, opts.TempStore)})
+	}
+	if reader {
+		pragmas = append(pragmas, pragmaValue{"query_only", 1})
+	}
+	for _, p := range pragmas {
+		var got string
+		if err = conn.QueryRowContext(ctx, "PRAGMA "+p.name).Scan(&got); err != nil {
+			return fmt.Errorf("sqlite: 读取 %s 失败: %w", p.name, err)
+		}
+		if !strings.EqualFold(got, fmt.Sprint(p.want)) {
+			return fmt.Errorf("sqlite: %s 设置为 %v, 实际是 %s", p.name, p.want, got)
+		}
+	}
+	return nil
+}
+
+// sqliteEnum 返回 value 在 list 中的位置, 也就是 PRAGMA 返回的数值, 不区分大小写, 找不到时返回 -1
+func sqliteEnum(list []string, value string) int {
+	for i, item := range list {
+		if strings.EqualFold(item, value) {
+			return i
+		}
+	}
+	return -1
+}
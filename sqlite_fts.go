@@ -0,0 +1,276 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FTSIndex 为一个表的部分列创建的 FTS5 全文索引. 索引是 external content 表, 不重复保存数据,
+// 由内容表上的触发器保持同步. 内容表需要有 rowid, 不支持 WITHOUT ROWID 表.
+//
+// FTS5 需要使用 -tags sqlite_fts5 构建, 否则创建时返回错误.
+type FTSIndex struct {
+	Table     string   // 内容表
+	Columns   []string // 索引的列
+	Name      string   // FTS5 表的名称, 默认是 <Table>_fts, 触发器的名称以它为前缀
+	Tokenizer string   // 分词器, 例如 "unicode61 remove_diacritics 2" 或者 "trigram", 为空时使用默认的 unicode61
+
+	// 片段中匹配的词前后加上的标记, 默认是 <mark> 和 </mark>
+	HighlightStart string
+	HighlightEnd   string
+	SnippetTokens  int // 片段最多包含的词数, 默认 16, 最大 64
+}
+
+// FTSResult 一条搜索结果
+type FTSResult struct {
+	RowID   int64   // 内容表中的 rowid
+	Rank    float64 // bm25 评分, 越小越相关
+	Snippet string  // 匹配最好的列中的片段, 匹配的词加上了标记
+}
+
+// FTS 已经创建的全文索引, 由 Db.CreateFTS 或 SQLiteMemDb.CreateFTS 返回, 可以在多个 goroutine 中使用.
+// 方法的错误通过创建它的 Db 或 SQLiteMemDb 的 ErrorReporter 输出
+type FTS struct {
+	index  FTSIndex
+	report func(skip int, err error) bool
+	query  func(sqlCase string, qc func(rows *sql.Rows) error, args ...interface{}) error
+	write  func(fn func(tx ftsTx) error) error
+}
+
+// ftsTx 同时适用于 *Tx 和 *sql.Tx
+type ftsTx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// CreateFTS 创建全文索引和同步的触发器, 然后从内容表加载已有的数据. 索引已经存在时不会重新创建和加载,
+// 所以可以在每次启动时调用. 修改了 Columns 或者 Tokenizer 时需要先调用 FTS.Drop.
+func (db *Db) CreateFTS(index FTSIndex) (*FTS, SqlResult) {
+	fts, err := db.createFTS(index)
+	if db.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return fts, SqlResult{}
+}
+
+func (db *Db) createFTS(index FTSIndex) (*FTS, error) {
+	if db.dbType != DatabaseTypeSqlite {
+		return nil, errors.New("全文索引只支持 SQLite")
+	}
+	fts := &FTS{
+		index:  index.withDefaults(),
+		report: db.reportError,
+		query:  db.query,
+		write: func(fn func(tx ftsTx) error) error {
+			tx, err := db.begin()
+			if err != nil {
+				return err
+			}
+			if err = fn(tx); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		},
+	}
+	return fts, fts.create()
+}
+
+// CreateFTS 在内存数据库上创建全文索引, 说明见 Db.CreateFTS. 通过写队列以外的方式修改内容表时, 触发器同样会更新索引
+func (mdb *SQLiteMemDb) CreateFTS(index FTSIndex) (*FTS, SqlResult) {
+	fts, err := mdb.createFTS(index)
+	if mdb.reportError(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return fts, SqlResult{}
+}
+
+func (mdb *SQLiteMemDb) createFTS(index FTSIndex) (*FTS, error) {
+	if !mdb.Open() {
+		return nil, errors.New("打开内存数据库失败")
+	}
+	fts := &FTS{
+		index:  index.withDefaults(),
+		report: mdb.reportError,
+		query: func(sqlCase string, qc func(rows *sql.Rows) error, args ...interface{}) error {
+			rows, err := mdb.Db.Query(sqlCase, args...)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = rows.Close()
+			}()
+			return qc(rows)
+		},
+		write: func(fn func(tx ftsTx) error) error {
+			return mdb.WriteTx(func(tx *sql.Tx) error {
+				return fn(tx)
+			})
+		},
+	}
+	return fts, fts.create()
+}
+
+func (index FTSIndex) withDefaults() FTSIndex {
+	if index.Name == "" {
+		index.Name = index.Table + "_fts"
+	}
+	if index.HighlightStart == "" && index.HighlightEnd == "" {
+		index.HighlightStart, index.HighlightEnd = "<mark>", "</mark>"
+	}
+	if index.SnippetTokens <= 0 {
+		index.SnippetTokens = 16
+	}
+	if index.SnippetTokens > 64 {
+		index.SnippetTokens = 64
+	}
+	return index
+}
+
+func (f *FTS) create() error {
+	index := f.index
+	if index.Table == "" || len(index.Columns) == 0 {
+		return errors.New("全文索引需要设置 Table 和 Columns")
+	}
+	return f.write(func(tx ftsTx) error {
+		ctx := context.Background()
+		var fts5 bool
+		err := tx.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+		if err != nil {
+			return err
+		}
+		if !fts5 {
+			return errors.New("sqlite: 没有编译 FTS5, 需要使用 -tags sqlite_fts5 构建")
+		}
+		var withoutRowid bool
+		err = tx.QueryRowContext(ctx, "SELECT wr FROM pragma_table_list WHERE schema = 'main' AND name = ?", index.Table).Scan(&withoutRowid)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("表 %s 不存在", index.Table)
+		}
+		if err != nil {
+			return err
+		}
+		if withoutRowid {
+			return fmt.Errorf("表 %s 是 WITHOUT ROWID 表, 不能创建全文索引", index.Table)
+		}
+		var exists int
+		err = tx.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", index.Name).Scan(&exists)
+		if err != nil || exists > 0 {
+			return err
+		}
+		for _, s := range index.createSQL() {
+			if _, err = tx.ExecContext(ctx, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createSQL 创建 FTS5 表和触发器, 最后从内容表重建索引
+func (index FTSIndex) createSQL() []string {
+	name := sqliteQuote(index.Name)
+	table := sqliteQuote(index.Table)
+	columns := sqliteQuoteList(index.Columns)
+	var newValues, oldValues []string
+	for _, col := range index.Columns {
+		newValues = append(newValues, "new."+sqliteQuote(col))
+		oldValues = append(oldValues, "old."+sqliteQuote(col))
+	}
+	insert := fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s);", name, columns, strings.Join(newValues, ", "))
+	remove := fmt.Sprintf("INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s);", name, name, columns, strings.Join(oldValues, ", "))
+
+	options := "content=" + sqliteString(index.Table)
+	if index.Tokenizer != "" {
+		options += ", tokenize=" + sqliteString(index.Tokenizer)
+	}
+	trigger := func(suffix string) string {
+		return sqliteQuote(index.Name + "_" + suffix)
+	}
+	return []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, %s)", name, columns, options),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s BEGIN %s END", trigger("ai"), table, insert),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER DELETE ON %s BEGIN %s END", trigger("ad"), table, remove),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE ON %s BEGIN %s %s END", trigger("au"), table, remove, insert),
+		fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", name, name),
+	}
+}
+
+// Search 搜索 query, 按相关程度排序返回最多 limit 条结果, limit 小于等于 0 时不限制.
+// query 使用 FTS5 的查询语法, 用户输入的文本可以先用 FTSQuery 转换. query 是空白时返回空的结果.
+func (f *FTS) Search(query string, limit int) ([]FTSResult, SqlResult) {
+	results, err := f.search(query, limit)
+	if f.report(errSkip, err) {
+		return nil, NewSqlResult(err)
+	}
+	return results, SqlResult{}
+}
+
+func (f *FTS) search(query string, limit int) ([]FTSResult, error) {
+	// FTS5 把空的查询当作语法错误, 例如 FTSQuery 转换空白的输入得到空串
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	name := sqliteQuote(f.index.Name)
+	sqlCase := fmt.Sprintf("SELECT rowid, rank, snippet(%s, -1, ?, ?, '…', ?) FROM %s WHERE %s MATCH ? ORDER BY rank LIMIT ?",
+		name, name, name)
+	var results []FTSResult
+	err := f.query(sqlCase, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var r FTSResult
+			if err := rows.Scan(&r.RowID, &r.Rank, &r.Snippet); err != nil {
+				return err
+			}
+			results = append(results, r)
+		}
+		return rows.Err()
+	}, f.index.HighlightStart, f.index.HighlightEnd, f.index.SnippetTokens, query, limit)
+	return results, err
+}
+
+// Rebuild 从内容表重新生成索引, 内容表的数据没有经过触发器修改时使用, 例如触发器创建之前导入的数据
+func (f *FTS) Rebuild() SqlResult {
+	name := sqliteQuote(f.index.Name)
+	err := f.write(func(tx ftsTx) error {
+		_, err := tx.ExecContext(context.Background(), fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", name, name))
+		return err
+	})
+	f.report(errSkip, err)
+	return NewSqlResult(err)
+}
+
+// Drop 删除全文索引和触发器, 内容表不受影响
+func (f *FTS) Drop() SqlResult {
+	statements := []string{
+		"DROP TRIGGER IF EXISTS " + sqliteQuote(f.index.Name+"_ai"),
+		"DROP TRIGGER IF EXISTS " + sqliteQuote(f.index.Name+"_ad"),
+		"DROP TRIGGER IF EXISTS " + sqliteQuote(f.index.Name+"_au"),
+		"DROP TABLE IF EXISTS " + sqliteQuote(f.index.Name),
+	}
+	err := f.write(func(tx ftsTx) error {
+		for _, s := range statements {
+			if _, err := tx.ExecContext(context.Background(), s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	f.report(errSkip, err)
+	return NewSqlResult(err)
+}
+
+// FTSQuery 把用户输入的文本转换为 FTS5 查询, 每个词作为短语匹配, 所有的词都需要出现,
+// 这样输入中的引号, 减号和 AND, OR 等不会被当作查询语法. 需要前缀匹配时在结果后面加上 *
+func FTSQuery(text string) string {
+	words := strings.Fields(text)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,109 @@
+//go:build sqlite_fts5
+
+package judb
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFTS(t *testing.T) {
+	opens := map[string]func(t *testing.T) (exec func(sqlCase string, args ...interface{}) SqlResult, create func(FTSIndex) (*FTS, SqlResult)){
+		"Db": func(t *testing.T) (func(string, ...interface{}) SqlResult, func(FTSIndex) (*FTS, SqlResult)) {
+			var db Db
+			if !db.OpenSqlite3(filepath.Join(t.TempDir(), "fts.db"), "") {
+				t.Fatal("open sqlite failed")
+			}
+			t.Cleanup(db.Close)
+			return db.Exec, db.CreateFTS
+		},
+		"SQLiteMemDb": func(t *testing.T) (func(string, ...interface{}) SqlResult, func(FTSIndex) (*FTS, SqlResult)) {
+			var mdb SQLiteMemDb
+			if !mdb.Open() {
+				t.Fatal("open memory database failed")
+			}
+			t.Cleanup(mdb.Close)
+			return mdb.Exec, mdb.CreateFTS
+		},
+	}
+	for name, open := range opens {
+		t.Run(name, func(t *testing.T) {
+			exec, create := open(t)
+			mustExec := func(sqlCase string, args ...interface{}) {
+				t.Helper()
+				if mr := exec(sqlCase, args...); mr.Fail() {
+					t.Fatalf("%s: %s", sqlCase, mr.Error)
+				}
+			}
+			mustExec("CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT, body TEXT)")
+			// 创建索引之前的数据由 CreateFTS 加载
+			mustExec("INSERT INTO posts VALUES (1, 'golang tips', 'use the race detector')")
+
+			fts, mr := create(FTSIndex{Table: "posts", Columns: []string{"title", "body"}})
+			if mr.Fail() {
+				t.Fatal(mr.Error)
+			}
+			search := func(query string) []FTSResult {
+				t.Helper()
+				results, mr := fts.Search(query, 0)
+				if mr.Fail() {
+					t.Fatalf("search %q: %s", query, mr.Error)
+				}
+				return results
+			}
+			expectIDs := func(query string, want ...int64) {
+				t.Helper()
+				results := search(query)
+				if len(results) != len(want) {
+					t.Fatalf("search %q = %v, want rowids %v", query, results, want)
+				}
+				for i, r := range results {
+					if r.RowID != want[i] {
+						t.Fatalf("search %q = %v, want rowids %v", query, results, want)
+					}
+				}
+			}
+			expectIDs("golang", 1)
+
+			mustExec("INSERT INTO posts VALUES (2, 'rust notes', 'golang is mentioned once')")
+			mustExec("INSERT INTO posts VALUES (3, 'golang golang', 'golang everywhere golang')")
+			// 匹配次数多的行评分更小, 排在前面
+			expectIDs("golang", 3, 1, 2)
+			results := search("race")
+			if len(results) != 1 || !strings.Contains(results[0].Snippet, "<mark>race</mark>") {
+				t.Fatalf("snippet = %v", results)
+			}
+			if results[0].Rank >= 0 {
+				t.Fatalf("rank = %v, want negative bm25", results[0].Rank)
+			}
+
+			mustExec("UPDATE posts SET body = 'nothing to see' WHERE id = 1")
+			expectIDs("race")
+			expectIDs("nothing", 1)
+
+			mustExec("DELETE FROM posts WHERE id = 3")
+			expectIDs("golang", 1, 2)
+
+			if results := search("  "); len(results) != 0 {
+				t.Fatalf("blank query = %v", results)
+			}
+			if results := search(FTSQuery("")); len(results) != 0 {
+				t.Fatalf("empty FTSQuery = %v", results)
+			}
+
+			if mr := fts.Rebuild(); mr.Fail() {
+				t.Fatal(mr.Error)
+			}
+			expectIDs("golang", 1, 2)
+			if mr := fts.Drop(); mr.Fail() {
+				t.Fatal(mr.Error)
+			}
+			// 删除索引后内容表的写入不再经过触发器
+			mustExec("INSERT INTO posts VALUES (4, 'after drop', 'golang')")
+			if _, mr := fts.Search("golang", 0); !mr.Fail() {
+				t.Fatal("search after drop succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package judb
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SqliteFunc Go 实现的 SQL 标量函数. Impl 是普通的 Go 函数, 参数和返回值的类型转换规则见 sqlite3.SQLiteConn.RegisterFunc,
+// 最后一个返回值可以是 error. Pure 表示相同的参数总是返回相同的结果, 这样的函数可以用于索引和 CHECK 约束.
+type SqliteFunc struct {
+	Name string
+	Impl interface{}
+	Pure bool
+}
+
+// SqliteAggregate Go 实现的聚合函数. Impl 是构造函数, 返回的对象需要有 Step 和 Done 两个方法,
+// 每一组数据调用一次构造函数, 每一行调用一次 Step, 最后调用 Done 返回结果, 详见 sqlite3.SQLiteConn.RegisterAggregator
+type SqliteAggregate struct {
+	Name string
+	Impl interface{}
+	Pure bool
+}
+
+// SqliteCollation 排序规则, Compare 返回负数, 0 或者正数, 用法是 COLLATE 名称
+type SqliteCollation struct {
+	Name    string
+	Compare func(a, b string) int
+}
+
+// SqliteFunctions 注册到每一个 SQLite 连接的函数和排序规则. 通过 Db.SetSqliteFunctions 或者 SQLiteMemDb.Functions 设置,
+// 打开数据库后不要再修改. 多个数据库可以共用同一个设置.
+type SqliteFunctions struct {
+	Scalars    []SqliteFunc
+	Aggregates []SqliteAggregate
+	Collations []SqliteCollation
+}
+
+func (f *SqliteFunctions) register(conn *sqlite3.SQLiteConn) error {
+	for _, fn := range f.Scalars {
+		if err := conn.RegisterFunc(fn.Name, fn.Impl, fn.Pure); err != nil {
+			return fmt.Errorf("sqlite: 注册函数 %s 失败: %w", fn.Name, err)
+		}
+	}
+	for _, fn := range f.Aggregates {
+		if err := conn.RegisterAggregator(fn.Name, fn.Impl, fn.Pure); err != nil {
+			return fmt.Errorf("sqlite: 注册聚合函数 %s 失败: %w", fn.Name, err)
+		}
+	}
+	for _, c := range f.Collations {
+		if err := conn.RegisterCollation(c.Name, c.Compare); err != nil {
+			return fmt.Errorf("sqlite: 注册排序规则 %s 失败: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// SetSqliteFunctions 设置注册到 SQLite 连接的函数, 必须在 OpenSqlite3 或 OpenSqlite3Options 之前调用.
+// 其它类型的数据库忽略这个设置.
+func (db *Db) SetSqliteFunctions(funcs *SqliteFunctions) {
+	db.sqliteFuncs = funcs
+}
+
+// openSqliteDB 打开连接时注册 funcs 的连接池. 使用自己的 SQLiteDriver, 不注册全局的驱动名称, 所以打开任意多个数据库
+// 也不会累积驱动. memory 为 true 时是内存数据库, 每个连接打开 read_uncommitted, 共享缓存模式下读取不会因为写入中的表被锁定而失败
+func openSqliteDB(funcs *SqliteFunctions, memory bool, dsn string) *sql.DB {
+	drv := &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		if memory {
+			if _, err := conn.Exec("PRAGMA read_uncommitted = 1", nil); err != nil {
+				return err
+			}
+		}
+		if funcs != nil {
+			return funcs.register(conn)
+		}
+		return nil
+	}}
+	return sql.OpenDB(&sqliteConnector{driver: drv, dsn: dsn})
+}
+
+// sqliteRegexpCacheSize SqliteRegexp 缓存的编译后的表达式数量
+const sqliteRegexpCacheSize = 128
+
+// SqliteRegexp REGEXP 运算符使用的 regexp 函数, 语法是 Go 的 regexp. 最近使用的表达式编译后会被缓存,
+// 表达式或者 value 为 NULL 时结果是 NULL, 例如 SELECT * FROM users WHERE email REGEXP '@example\.com$'
+func SqliteRegexp() SqliteFunc {
+	cache := newRegexpCache(sqliteRegexpCacheSize)
+	return SqliteFunc{
+		Name: "regexp",
+		Pure: true,
+		Impl: func(pattern, value interface{}) (interface{}, error) {
+			if sqliteIsNull(pattern) || sqliteIsNull(value) {
+				return nil, nil
+			}
+			re, err := cache.get(sqliteText(pattern))
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(sqliteText(value)), nil
+		},
+	}
+}
+
+// sqliteIsNull 驱动把 NULL 参数作为 nil 的 []byte 传入 interface{} 类型的参数
+func sqliteIsNull(v interface{}) bool {
+	b, ok := v.([]byte)
+	return v == nil || ok && b == nil
+}
+
+// sqliteText 把 SQLite 传入的值转换为文本
+func sqliteText(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	}
+	return fmt.Sprint(v)
+}
+
+// regexpCache 编译后的表达式的 LRU 缓存, 可以在多个 goroutine 中使用
+type regexpCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // 最近使用的在前面
+	entries map[string]*list.Element
+}
+
+type regexpEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newRegexpCache(size int) *regexpCache {
+	return &regexpCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *regexpCache) get(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*regexpEntry).re, nil
+	}
+	c.mu.Unlock()
+	// 编译时不持有锁, 两个 goroutine 同时编译同一个表达式时只保留一个
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*regexpEntry).re, nil
+	}
+	c.entries[pattern] = c.order.PushFront(&regexpEntry{pattern: pattern, re: re})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*regexpEntry).pattern)
+	}
+	return re, nil
+}
+
+// SqliteUnicodeNoCase 不区分大小写的排序规则 UNICODE_NOCASE, 和 SQLite 自带的 NOCASE 不同, 它支持全部 Unicode 字符,
+// 例如 SELECT * FROM users ORDER BY name COLLATE UNICODE_NOCASE
+func SqliteUnicodeNoCase() SqliteCollation {
+	return SqliteCollation{Name: "UNICODE_NOCASE", Compare: unicodeNoCaseCompare}
+}
+
+// unicodeNoCaseCompare 逐个比较字符的简单大小写折叠结果
+func unicodeNoCaseCompare(a, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if ra != rb {
+			fa, fb := unicodeFold(ra), unicodeFold(rb)
+			if fa != fb {
+				if fa < fb {
+					return -1
+				}
+				return 1
+			}
+		}
+		a, b = a[na:], b[nb:]
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	}
+	return 1
+}
+
+// unicodeFold 返回大小写折叠等价类中最小的字符, 等价的字符返回相同的值
+func unicodeFold(r rune) rune {
+	folded := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < folded {
+			folded = f
+		}
+	}
+	return folded
+}
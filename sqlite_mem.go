@@ -1,117 +1,395 @@
 package judb
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/jsuserapp/ju"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-// SQLiteMemDb SQLite 内存数据库, 它的函数并不支持并发, 但并不是说不能多线程调用, 而是不能频繁调用
-// 普通的确认没有线程竞争的情况下是可以多线程调用的. 内存模式的数据库, 无论设置什么参数, 都不支持多线程
-// 同时写
+// SQLiteMemDb SQLite 内存数据库. 内存模式的数据库不支持多个连接同时写, 所以 Exec, Write, WriteTx 和文件的加载保存
+// 都放入一个队列, 由一个 goroutine 在同一个连接上依次执行, 可以在多个 goroutine 中同时调用. Query 和 QueryRow
+// 使用连接池中的其它连接, 可以并行读取. 直接使用 Db 写入时不经过队列, 和其它写操作同时进行会出现 table is locked 错误.
+//
+// 每个 SQLiteMemDb 使用独立的内存数据库. Name 为空时 Open 生成唯一的名称并写回 Name, 也可以由调用者指定名称.
+// 名称已经被进程中其它打开的 SQLiteMemDb 使用时, 只有设置了 Shared 才能打开, 这时两者共享同一个数据库.
+// Open 会保留写入连接直到 Close, 所以连接池关闭空闲连接时数据不会丢失. 共享的 SQLiteMemDb 各自有自己的写队列,
+// 它们之间的写操作不会排队.
 type SQLiteMemDb struct {
-	Db *sql.DB
+	Db     *sql.DB
+	Name   string // 内存数据库的名称
+	Shared bool   // 允许打开其它 SQLiteMemDb 正在使用的名称
+	// Functions 注册到连接的函数和排序规则, 需要在 Open 之前设置
+	Functions *SqliteFunctions
+	// Reporter 错误输出方式, 为 nil 时使用 JuConsoleReporter 输出到控制台
+	Reporter ErrorReporter
+
+	keeper *sql.Conn // 写入连接
+	writer *memWriter
+	// persist 写队列的每次写入都会读取, 和 StartPersist 可能在不同的 goroutine 中, 使用原子操作
+	persist atomic.Pointer[memPersist]
+	// stopped Close 时停止的自动保存, 之后 PersistStats 仍然返回它的统计, 包括最后一次保存的结果
+	stopped atomic.Pointer[memPersist]
+	mirror  atomic.Pointer[memMirror]
 }
 
+var memDbNames = struct {
+	sync.Mutex
+	refs map[string]int
+	seq  uint64
+}{refs: map[string]int{}}
+
 func (mdb *SQLiteMemDb) Open() bool {
 	if mdb.Db != nil {
 		return true
 	}
-	source := "file:shared_mem?mode=memory&cache=shared"
-	db, err := sql.Open("sqlite3", source)
-	if err != nil {
-		ju.OutputColor(0, ju.ColorRed, err.Error())
+	if err := mdb.open(); err != nil {
+		mdb.reportError(0, err)
 		return false
 	}
+	return true
+}
+
+func (mdb *SQLiteMemDb) open() error {
+	memDbNames.Lock()
+	if mdb.Name == "" {
+		for {
+			memDbNames.seq++
+			mdb.Name = fmt.Sprintf("judb_mem_%d_%d", os.Getpid(), memDbNames.seq)
+			if memDbNames.refs[mdb.Name] == 0 {
+				break
+			}
+		}
+	} else if memDbNames.refs[mdb.Name] > 0 && !mdb.Shared {
+		memDbNames.Unlock()
+		return fmt.Errorf("内存数据库 %s 已经被使用, 需要共享时设置 Shared", mdb.Name)
+	}
+	memDbNames.refs[mdb.Name]++
+	memDbNames.Unlock()
+
+	source := "file:" + url.PathEscape(mdb.Name) + "?mode=memory&cache=shared"
+	db := openSqliteDB(mdb.Functions, true, source)
+	// 内存数据库在最后一个连接关闭时销毁, 写入连接一直保留, 不放回连接池
+	keeper, err := db.Conn(context.Background())
+	if err != nil {
+		_ = db.Close()
+		releaseMemDbName(mdb.Name)
+		return err
+	}
 	mdb.Db = db
+	mdb.keeper = keeper
+	mdb.startWriter()
+	return nil
+}
+
+// reportError err 不是 nil 时通过 Reporter 输出错误并返回 true, skip 的含义和 Db.reportError 相同
+func (mdb *SQLiteMemDb) reportError(skip int, err error) bool {
+	if err == nil {
+		return false
+	}
+	reporter := mdb.Reporter
+	if reporter == nil {
+		reporter = JuConsoleReporter{}
+	}
+	reporter.Report(skip+1, err)
 	return true
 }
+
+func releaseMemDbName(name string) {
+	memDbNames.Lock()
+	defer memDbNames.Unlock()
+	if memDbNames.refs[name]--; memDbNames.refs[name] <= 0 {
+		delete(memDbNames.refs, name)
+	}
+}
+
+// Close 关闭数据库, 没有其它共享的 SQLiteMemDb 时, 内存数据库中的数据随之销毁. 开始了自动保存时, 关闭前会保存最后一次
 func (mdb *SQLiteMemDb) Close() {
 	if mdb.Db != nil {
+		mdb.stopMirror()
+		mdb.stopPersist()
+		mdb.stopWriter()
+		_ = mdb.keeper.Close()
 		_ = mdb.Db.Close()
 		mdb.Db = nil
+		mdb.keeper = nil
+		mdb.writer = nil
+		releaseMemDbName(mdb.Name)
 	}
 }
 
-// LoadFromFile 加载数据库内容到内存数据库
+// LoadFromFile 把文件数据库的全部内容加载到内存数据库, 内存数据库中原有的内容会被替换, 详细说明见 LoadFromFileContext
 func (mdb *SQLiteMemDb) LoadFromFile(fileDB string) bool {
-	if !mdb.Open() {
+	err := mdb.LoadFromFileContext(context.Background(), fileDB, BackupOptions{})
+	if err != nil {
+		mdb.reportError(0, err)
 		return false
 	}
+	return true
+}
+
+type sqliteObject struct {
+	typ, name, sql string
+}
 
-	// 附加文件数据库
-	_, err := mdb.Db.Exec(fmt.Sprintf("ATTACH DATABASE '%s' AS filedb", fileDB))
+// loadSqliteFile 通过 ATTACH 逐表复制文件数据库的全部内容, 包括表, 索引, 视图, 触发器, AUTOINCREMENT 的序列,
+// WITHOUT ROWID 表和虚拟表. 复制在一个事务中完成, 失败时内存数据库保持原来的内容.
+// ATTACH 只对当前连接有效, 所以全部操作都在同一个连接上执行
+// key 不为空时文件是加密的数据库
+func loadSqliteFile(ctx context.Context, conn *sql.Conn, fileDB, key string) error {
+	// 复制数据的顺序和外键无关, 需要关闭外键约束, 这个设置在事务中无效
+	var foreignKeys int
+	err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys)
 	if err != nil {
-		ju.OutputColor(0, ju.ColorRed, err.Error())
-		return false
+		return err
+	}
+	if foreignKeys != 0 {
+		if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer func() {
+			_, _ = conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+		}()
+	}
+
+	if key != "" {
+		if err = checkSqlcipher(ctx, conn); err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS judb_src KEY ?", fileDB, key)
+	} else {
+		_, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS judb_src", fileDB)
+	}
+	if err != nil {
+		return err
 	}
 	defer func() {
-		_, err = mdb.Db.Exec("DETACH DATABASE filedb")
+		_, _ = conn.ExecContext(context.Background(), "DETACH DATABASE judb_src")
+	}()
+
+	if _, err = conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	if err = copySqliteSchema(ctx, conn); err != nil {
+		_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	return err
+}
+
+// copySqliteSchema 删除 main 中的全部对象, 然后从 judb_src 复制. 表先创建并复制数据, 然后创建索引, 视图和触发器,
+// 这样复制数据时不会触发触发器. 虚拟表的影子表由虚拟表自己创建, 只复制数据.
+func copySqliteSchema(ctx context.Context, conn *sql.Conn) error {
+	if err := dropSqliteObjects(ctx, conn); err != nil {
+		return err
+	}
+	objects, err := sqliteObjects(ctx, conn, "judb_src")
+	if err != nil {
+		return err
+	}
+	shadow, err := sqliteShadowTables(ctx, conn, "judb_src")
+	if err != nil {
+		return err
+	}
+
+	var tables []string
+	for _, obj := range objects {
+		if obj.typ != "table" || shadow[obj.name] {
+			continue
+		}
+		if _, err = conn.ExecContext(ctx, obj.sql); err != nil {
+			return fmt.Errorf("创建表 %s 失败: %w", obj.name, err)
+		}
+		if !isVirtualTable(obj.sql) {
+			tables = append(tables, obj.name)
+		}
+	}
+	for name := range shadow {
+		tables = append(tables, name)
+		// 影子表在创建虚拟表时已经写入了初始数据
+		if _, err = conn.ExecContext(ctx, "DELETE FROM main."+sqliteQuote(name)); err != nil {
+			return err
+		}
+	}
+	for _, name := range tables {
+		columns, err := sqliteInsertColumns(ctx, conn, name)
 		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
+			return err
 		}
-	}()
+		sqlCase := fmt.Sprintf("INSERT INTO main.%s (%s) SELECT %s FROM judb_src.%s", sqliteQuote(name), columns, columns, sqliteQuote(name))
+		if _, err = conn.ExecContext(ctx, sqlCase); err != nil {
+			return fmt.Errorf("复制表 %s 的数据失败: %w", name, err)
+		}
+	}
 
-	// 查询文件数据库中的表结构
-	rows, err := mdb.Db.Query("SELECT name,`sql` FROM filedb.sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'")
+	// 复制数据时序列已经更新为最大的 id, 源数据库的序列可能更大 (删除过数据)
+	var hasSequence int
+	err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM judb_src.sqlite_master WHERE name = 'sqlite_sequence'").Scan(&hasSequence)
 	if err != nil {
-		ju.OutputColor(0, ju.ColorRed, err.Error())
-		return false
+		return err
+	}
+	if hasSequence > 0 {
+		if _, err = conn.ExecContext(ctx, "DELETE FROM main.sqlite_sequence"); err != nil {
+			return err
+		}
+		if _, err = conn.ExecContext(ctx, "INSERT INTO main.sqlite_sequence (name, seq) SELECT name, seq FROM judb_src.sqlite_sequence"); err != nil {
+			return err
+		}
 	}
-	rows, err = mdb.Db.Query("SELECT * FROM filedb.users")
-	defer rows.Close()
 
-	// 遍历所有表并复制数据
-	for rows.Next() {
-		var name, sqlCase string
-		if err := rows.Scan(&name, &sqlCase); err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
-			return false
+	for _, typ := range []string{"index", "view", "trigger"} {
+		var pending []sqliteObject
+		for _, obj := range objects {
+			if obj.typ == typ && !shadow[obj.name] {
+				pending = append(pending, obj)
+			}
 		}
+		// 视图可以引用后面创建的视图, 失败的对象在其它对象创建后重试, 直到没有进展
+		for len(pending) > 0 {
+			var failed []sqliteObject
+			var lastErr error
+			for _, obj := range pending {
+				if _, err = conn.ExecContext(ctx, obj.sql); err != nil {
+					failed = append(failed, obj)
+					lastErr = fmt.Errorf("创建 %s %s 失败: %w", obj.typ, obj.name, err)
+				}
+			}
+			if len(failed) == len(pending) {
+				return lastErr
+			}
+			pending = failed
+		}
+	}
+	return nil
+}
 
-		// 在内存数据库中创建表
-		_, err = mdb.Db.Exec(sqlCase)
-		if err != nil {
-			// 回滚事务
-			mdb.Db.Exec(`ROLLBACK;`)
-			ju.OutputColor(0, ju.ColorRed, err.Error())
-			return false
+// dropSqliteObjects 删除 main 中的全部表和视图, 索引和触发器随表一起删除. 先删除虚拟表, 它会删除自己的影子表
+func dropSqliteObjects(ctx context.Context, conn *sql.Conn) error {
+	objects, err := sqliteObjects(ctx, conn, "main")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if obj.typ == "view" {
+			if _, err = conn.ExecContext(ctx, "DROP VIEW main."+sqliteQuote(obj.name)); err != nil {
+				return err
+			}
 		}
+	}
+	for _, virtual := range []bool{true, false} {
+		for _, obj := range objects {
+			if obj.typ == "table" && isVirtualTable(obj.sql) == virtual {
+				if _, err = conn.ExecContext(ctx, "DROP TABLE IF EXISTS main."+sqliteQuote(obj.name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
 
-		// 开始事务
-		_, err := mdb.Db.Exec(`BEGIN TRANSACTION;`)
-		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
-			continue
+// sqliteObjects 按创建的顺序返回 schema 中的对象, 不包含 SQLite 内部的对象和自动创建的索引
+func sqliteObjects(ctx context.Context, conn *sql.Conn, schema string) ([]sqliteObject, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT type, name, sql FROM %s.sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%%' ORDER BY rowid", schema))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var objects []sqliteObject
+	for rows.Next() {
+		var obj sqliteObject
+		if err = rows.Scan(&obj.typ, &obj.name, &obj.sql); err != nil {
+			return nil, err
 		}
-		// 将数据从文件数据库复制到内存数据库
-		_, err = mdb.Db.Exec(fmt.Sprintf("INSERT INTO %s SELECT * FROM filedb.%s", name, name))
-		if err != nil {
-			// 回滚事务
-			mdb.Db.Exec(`ROLLBACK;`)
-			ju.OutputColor(0, ju.ColorRed, err.Error())
-			return false
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// sqliteShadowTables 虚拟表 (例如 FTS5) 的影子表. 是否是影子表由虚拟表的模块决定, 名称只是虚拟表的名称加下划线开头的表
+// 可能是普通的表, 所以使用 pragma_table_list 的结果, 虚拟表的模块没有注册时它的影子表被当作普通的表
+func sqliteShadowTables(ctx context.Context, conn *sql.Conn, schema string) (map[string]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM pragma_table_list WHERE schema = ? AND type = 'shadow'", schema)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	shadow := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
 		}
+		shadow[name] = true
+	}
+	return shadow, rows.Err()
+}
 
-		// 提交事务
-		_, err = mdb.Db.Exec(`COMMIT;`)
-		if err != nil {
-			ju.OutputColor(0, ju.ColorRed, err.Error())
-			return false
+func isVirtualTable(sqlCase string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.Join(strings.Fields(sqlCase), " ")), "CREATE VIRTUAL TABLE")
+}
+
+// sqliteInsertColumns 返回可以插入的列, 生成列不能插入. 有 rowid 并且没有 INTEGER PRIMARY KEY 的表同时复制 rowid,
+// 外部内容的 FTS 等会通过 rowid 引用表中的行
+func sqliteInsertColumns(ctx context.Context, conn *sql.Conn, table string) (string, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT name, type, pk FROM pragma_table_xinfo(?, 'judb_src') WHERE hidden = 0 ORDER BY cid", table)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var columns []string
+	pkCount := 0
+	integerPK := false
+	for rows.Next() {
+		var name, typ string
+		var pk int
+		if err = rows.Scan(&name, &typ, &pk); err != nil {
+			return "", err
 		}
+		if pk > 0 {
+			pkCount++
+			integerPK = strings.EqualFold(typ, "INTEGER")
+		}
+		columns = append(columns, sqliteQuote(name))
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("表 %s 没有可以复制的列", table)
 	}
+	if pkCount != 1 || !integerPK {
+		// WITHOUT ROWID 的表查询 rowid 会失败
+		var rowid sql.NullInt64
+		err = conn.QueryRowContext(ctx, fmt.Sprintf("SELECT rowid FROM judb_src.%s LIMIT 1", sqliteQuote(table))).Scan(&rowid)
+		if err == nil || errors.Is(err, sql.ErrNoRows) {
+			columns = append([]string{"rowid"}, columns...)
+		}
+	}
+	return strings.Join(columns, ", "), nil
+}
 
-	return true
+func sqliteQuote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
 }
 
-// SaveToFile 这会把内存数据保存到指定的文件, 如果文件存在, 会被覆盖
+// SaveToFile 这会把内存数据保存到指定的文件, 如果文件存在, 会被覆盖, 详细说明见 SaveToFileContext
 func (mdb *SQLiteMemDb) SaveToFile(fileDB string) bool {
-	if mdb.Db == nil {
-		return false
-	}
-	_, err := mdb.Db.Exec(fmt.Sprintf("VACUUM INTO '%s'", fileDB))
+	err := mdb.SaveToFileContext(context.Background(), fileDB, BackupOptions{})
 	if err != nil {
-		ju.OutputColor(0, ju.ColorRed, err.Error())
+		mdb.reportError(0, err)
 		return false
 	}
 	return true
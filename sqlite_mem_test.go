@@ -0,0 +1,220 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSQLiteMemDbLoadFromFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		fts5  bool
+		setup []string
+		check func(t *testing.T, mdb *SQLiteMemDb)
+	}{
+		{
+			name: "autoincrement",
+			setup: []string{
+				"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)",
+				"INSERT INTO users (name) VALUES ('a'), ('b'), ('c')",
+				"DELETE FROM users WHERE id = 3",
+			},
+			check: func(t *testing.T, mdb *SQLiteMemDb) {
+				var seq int64
+				if err := mdb.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = 'users'").Scan(&seq); err != nil {
+					t.Fatal(err)
+				}
+				if seq != 3 {
+					t.Fatalf("sqlite_sequence = %d, want 3", seq)
+				}
+				mr := mdb.Exec("INSERT INTO users (name) VALUES ('d')")
+				if mr.Error != "" {
+					t.Fatal(mr.Error)
+				}
+				if id, _ := mr.Result.LastInsertId(); id != 4 {
+					t.Fatalf("new id = %d, want 4", id)
+				}
+			},
+		},
+		{
+			name: "without rowid",
+			setup: []string{
+				"CREATE TABLE kv (k TEXT PRIMARY KEY, v TEXT) WITHOUT ROWID",
+				"INSERT INTO kv VALUES ('a', '1'), ('b', '2')",
+			},
+			check: func(t *testing.T, mdb *SQLiteMemDb) {
+				var wr bool
+				if err := mdb.QueryRow("SELECT wr FROM pragma_table_list WHERE schema = 'main' AND name = 'kv'").Scan(&wr); err != nil {
+					t.Fatal(err)
+				}
+				if !wr {
+					t.Fatal("kv lost WITHOUT ROWID")
+				}
+				expectCount(t, mdb, "SELECT count(*) FROM kv", 2)
+			},
+		},
+		{
+			name: "generated columns",
+			setup: []string{
+				"CREATE TABLE g (a INTEGER, b INTEGER GENERATED ALWAYS AS (a * 2) STORED, c INTEGER AS (a + 1))",
+				"INSERT INTO g (a) VALUES (1), (5)",
+			},
+			check: func(t *testing.T, mdb *SQLiteMemDb) {
+				var b, c int
+				if err := mdb.QueryRow("SELECT b, c FROM g WHERE a = 5").Scan(&b, &c); err != nil {
+					t.Fatal(err)
+				}
+				if b != 10 || c != 6 {
+					t.Fatalf("b, c = %d, %d, want 10, 6", b, c)
+				}
+			},
+		},
+		{
+			name: "views and triggers",
+			setup: []string{
+				"CREATE TABLE items (id INTEGER PRIMARY KEY, price INTEGER)",
+				"CREATE TABLE audit (item_id INTEGER)",
+				"CREATE VIEW expensive AS SELECT id FROM items WHERE price > 10",
+				"CREATE TRIGGER items_ai AFTER INSERT ON items BEGIN INSERT INTO audit VALUES (new.id); END",
+				"INSERT INTO items VALUES (1, 5), (2, 20)",
+			},
+			check: func(t *testing.T, mdb *SQLiteMemDb) {
+				// 复制数据时不会触发触发器, audit 只有源数据库中的两行
+				expectCount(t, mdb, "SELECT count(*) FROM audit", 2)
+				expectCount(t, mdb, "SELECT count(*) FROM expensive", 1)
+				if mr := mdb.Exec("INSERT INTO items VALUES (3, 30)"); mr.Error != "" {
+					t.Fatal(mr.Error)
+				}
+				expectCount(t, mdb, "SELECT count(*) FROM audit", 3)
+				expectCount(t, mdb, "SELECT count(*) FROM expensive", 2)
+			},
+		},
+		{
+			name: "fts5 next to ordinary table with same prefix",
+			fts5: true,
+			setup: []string{
+				"CREATE VIRTUAL TABLE docs USING fts5(body)",
+				"CREATE TABLE docs_x (v TEXT)",
+				"INSERT INTO docs (body) VALUES ('hello world'), ('goodbye')",
+				"INSERT INTO docs_x VALUES ('x1'), ('x2'), ('x3')",
+			},
+			check: func(t *testing.T, mdb *SQLiteMemDb) {
+				expectCount(t, mdb, "SELECT count(*) FROM docs_x", 3)
+				expectCount(t, mdb, "SELECT count(*) FROM docs WHERE docs MATCH 'hello'", 1)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "src.db")
+			fdb, err := sql.Open("sqlite3", file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fdb.Close()
+			}()
+			if tt.fts5 {
+				var fts5 bool
+				if err = fdb.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+					t.Fatal(err)
+				}
+				if !fts5 {
+					t.Skip("需要使用 -tags sqlite_fts5 构建")
+				}
+			}
+			for _, s := range tt.setup {
+				if _, err = fdb.Exec(s); err != nil {
+					t.Fatalf("%s: %v", s, err)
+				}
+			}
+
+			// LoadFromFile 使用 backup, 页大小不同时使用 loadSqliteFile 逐表复制, 两种方式都需要检查
+			loaders := map[string]func(mdb *SQLiteMemDb) error{
+				"backup": func(mdb *SQLiteMemDb) error {
+					return mdb.LoadFromFileContext(context.Background(), file, BackupOptions{})
+				},
+				"copy": func(mdb *SQLiteMemDb) error {
+					return mdb.write(context.Background(), func(conn *sql.Conn) error {
+						return loadSqliteFile(context.Background(), conn, file, "")
+					})
+				},
+			}
+			for name, load := range loaders {
+				t.Run(name, func(t *testing.T) {
+					var mdb SQLiteMemDb
+					if !mdb.Open() {
+						t.Fatal("open memory database failed")
+					}
+					defer mdb.Close()
+					if err := load(&mdb); err != nil {
+						t.Fatal(err)
+					}
+					tt.check(t, &mdb)
+				})
+			}
+		})
+	}
+}
+
+func expectCount(t *testing.T, mdb *SQLiteMemDb, sqlCase string, want int) {
+	t.Helper()
+	var n int
+	if err := mdb.QueryRow(sqlCase).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", sqlCase, err)
+	}
+	if n != want {
+		t.Fatalf("%s = %d, want %d", sqlCase, n, want)
+	}
+}
+
+func TestSaveToFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 没有 Unix 权限")
+	}
+	dir := t.TempDir()
+	// 用 os.Create 创建的文件的权限作为 0644 去掉 umask 之后的期望值
+	ref, err := os.Create(filepath.Join(dir, "ref"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := ref.Stat()
+	_ = ref.Close()
+	want := info.Mode().Perm()
+
+	var mdb SQLiteMemDb
+	if !mdb.Open() {
+		t.Fatal("open memory database failed")
+	}
+	defer mdb.Close()
+	if mr := mdb.Exec("CREATE TABLE t (v INTEGER)"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	file := filepath.Join(dir, "mem.db")
+	ctx := context.Background()
+	checkMode := func(want os.FileMode) {
+		t.Helper()
+		if err := mdb.SaveToFileContext(ctx, file, BackupOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != want {
+			t.Fatalf("mode = %v, want %v", perm, want)
+		}
+		if matches, _ := filepath.Glob(file + ".*.tmp"); len(matches) != 0 {
+			t.Fatalf("SaveToFileContext left files %v", matches)
+		}
+	}
+	checkMode(want)
+	if err := os.Chmod(file, 0640); err != nil {
+		t.Fatal(err)
+	}
+	checkMode(0640)
+}
@@ -0,0 +1,456 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MirrorTable 一个镜像到内存数据库的表. 内存数据库中的表和源表同名, 列的类型按下面的规则转换为 SQLite 的类型:
+// 整数和布尔值是 INTEGER, 浮点数是 REAL, DECIMAL 和 NUMERIC 是 TEXT 以保持精度, 日期时间是 TIMESTAMP, 日期是 DATE,
+// 二进制数据是 BLOB, 其它类型都是 TEXT. 主键和只包含普通列的索引也会一起创建, 默认值和外键不会复制.
+type MirrorTable struct {
+	Table string
+	// Where 只加载满足条件的行, 为空时加载全部, 条件中使用 ? 占位符, 参数是 Args
+	Where string
+	Args  []interface{}
+	// WriteThrough 允许通过 MirrorExec 修改这个表, 修改先在源数据库执行, 成功后再在内存数据库执行
+	WriteThrough bool
+}
+
+// MirrorOptions SQLiteMemDb 镜像的设置
+type MirrorOptions struct {
+	Source   *Db // 源数据库, 可以是任何类型的 Db
+	Tables   []MirrorTable
+	Interval time.Duration // 定时刷新的间隔, 0 表示只在调用 RefreshMirror 时刷新
+}
+
+// MirrorStatus 一个镜像表的状态
+type MirrorStatus struct {
+	Table         string
+	Rows          int64         // 最后一次刷新加载的行数
+	LastRefresh   time.Time     // 最后一次成功刷新的时间
+	LastDuration  time.Duration // 最后一次成功刷新的耗时
+	Errors        int64         // 刷新失败的次数
+	LastError     string
+	LastErrorTime time.Time
+}
+
+type memMirror struct {
+	options MirrorOptions
+	tables  map[string]*MirrorTable
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu     sync.Mutex // 刷新和 MirrorExec 依次执行, 避免刷新读取的旧数据覆盖新的修改
+	status map[string]*MirrorStatus
+}
+
+// StartMirror 从源数据库加载表到内存数据库, 设置了 Interval 时定时刷新, Close 时停止.
+// 刷新时先在新表中加载全部数据, 再在一个事务中替换原来的表, 读取不会看到加载了一半的表.
+func (mdb *SQLiteMemDb) StartMirror(options MirrorOptions) error {
+	if !mdb.Open() {
+		return errors.New("打开内存数据库失败")
+	}
+	if mdb.mirror.Load() != nil {
+		return errors.New("已经开始镜像")
+	}
+	if options.Source == nil || options.Source.db == nil {
+		return errors.New("源数据库没有打开")
+	}
+	if len(options.Tables) == 0 {
+		return errors.New("没有设置镜像的表")
+	}
+	m := &memMirror{
+		options: options,
+		tables:  map[string]*MirrorTable{},
+		status:  map[string]*MirrorStatus{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for i := range options.Tables {
+		t := &options.Tables[i]
+		if m.tables[t.Table] != nil {
+			return fmt.Errorf("表 %s 重复", t.Table)
+		}
+		m.tables[t.Table] = t
+		m.status[t.Table] = &MirrorStatus{Table: t.Table}
+	}
+	if err := mdb.refreshMirror(m, nil); err != nil {
+		return err
+	}
+	if !mdb.mirror.CompareAndSwap(nil, m) {
+		return errors.New("已经开始镜像")
+	}
+	if options.Interval > 0 {
+		go mdb.mirrorLoop(m)
+	} else {
+		close(m.done)
+	}
+	return nil
+}
+
+// RefreshMirror 立即刷新指定的表, 没有指定时刷新全部镜像的表
+func (mdb *SQLiteMemDb) RefreshMirror(tables ...string) error {
+	m := mdb.mirror.Load()
+	if m == nil {
+		return errors.New("没有开始镜像")
+	}
+	for _, table := range tables {
+		if m.tables[table] == nil {
+			return fmt.Errorf("表 %s 没有镜像", table)
+		}
+	}
+	return mdb.refreshMirror(m, tables)
+}
+
+// MirrorStatus 返回全部镜像表的状态, 顺序和 MirrorOptions.Tables 相同
+func (mdb *SQLiteMemDb) MirrorStatus() []MirrorStatus {
+	m := mdb.mirror.Load()
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]MirrorStatus, 0, len(m.options.Tables))
+	for _, t := range m.options.Tables {
+		list = append(list, *m.status[t.Table])
+	}
+	return list
+}
+
+// MirrorExec 修改设置了 WriteThrough 的镜像表 table. sqlCase 使用 ? 占位符, 先在源数据库执行, 成功后再在内存数据库执行,
+// 所以语句需要同时适用于两种数据库. 内存数据库执行失败时会重新从源数据库加载这个表. 返回的是源数据库的执行结果.
+func (mdb *SQLiteMemDb) MirrorExec(table, sqlCase string, args ...interface{}) SqlResult {
+	err := mdb.mirrorExec(table, sqlCase, args)
+	if err != nil {
+		mdb.reportError(errSkip, err)
+		return NewSqlResult(err)
+	}
+	return SqlResult{}
+}
+
+func (mdb *SQLiteMemDb) mirrorExec(table, sqlCase string, args []interface{}) error {
+	m := mdb.mirror.Load()
+	if m == nil {
+		return errors.New("没有开始镜像")
+	}
+	t := m.tables[table]
+	if t == nil {
+		return fmt.Errorf("表 %s 没有镜像", table)
+	}
+	if !t.WriteThrough {
+		return fmt.Errorf("表 %s 没有设置 WriteThrough", table)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source := m.options.Source
+	if _, err := source.exec(source.Rebind(sqlCase), args...); err != nil {
+		return err
+	}
+	err := mdb.write(context.Background(), func(conn *sql.Conn) error {
+		_, err := conn.ExecContext(context.Background(), sqlCase, args...)
+		return err
+	})
+	if err == nil {
+		mdb.countWrite()
+		return nil
+	}
+	// 源数据库已经修改, 重新加载使内存数据库和源数据库一致
+	if rerr := mdb.refreshTable(m, t); rerr != nil {
+		return fmt.Errorf("源数据库已经修改, 但是内存数据库执行失败: %v, 重新加载也失败: %v", err, rerr)
+	}
+	return nil
+}
+
+func (mdb *SQLiteMemDb) mirrorLoop(m *memMirror) {
+	defer close(m.done)
+	ticker := time.NewTicker(m.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			mdb.reportError(0, mdb.refreshMirror(m, nil))
+		}
+	}
+}
+
+// refreshMirror 刷新 tables 中的表, tables 为空时刷新全部, 一个表失败不影响其它的表, 返回第一个错误
+func (mdb *SQLiteMemDb) refreshMirror(m *memMirror, tables []string) error {
+	if len(tables) == 0 {
+		for _, t := range m.options.Tables {
+			tables = append(tables, t.Table)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var first error
+	for _, table := range tables {
+		if err := mdb.refreshTable(m, m.tables[table]); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// refreshTable 调用时需要持有 m.mu
+func (mdb *SQLiteMemDb) refreshTable(m *memMirror, t *MirrorTable) error {
+	start := time.Now()
+	rows, err := mdb.loadMirrorTable(m.options.Source, t)
+	status := m.status[t.Table]
+	if err != nil {
+		err = fmt.Errorf("镜像表 %s 失败: %w", t.Table, err)
+		status.Errors++
+		status.LastError = err.Error()
+		status.LastErrorTime = time.Now()
+		return err
+	}
+	status.Rows = rows
+	status.LastRefresh = start
+	status.LastDuration = time.Since(start)
+	return nil
+}
+
+// mirrorBatchSize 加载镜像表时每次写操作插入的行数
+const mirrorBatchSize = 500
+
+// loadMirrorTable 从源数据库读取表的结构和全部数据, 然后在写入连接上替换内存数据库中的表. 数据边读取边分批插入临时表,
+// 每一批是一个写操作, 读取源数据库时不占用写队列, 全部插入后在一个事务中用临时表替换原来的表
+func (mdb *SQLiteMemDb) loadMirrorTable(source *Db, t *MirrorTable) (int64, error) {
+	columns, err := source.columns(t.Table)
+	if err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("表 %s 不存在", t.Table)
+	}
+	primaryKey, err := source.primaryKey(t.Table)
+	if err != nil {
+		return 0, err
+	}
+	indexes, err := source.indexes(t.Table)
+	if err != nil {
+		return 0, err
+	}
+
+	names := make([]string, len(columns))
+	types := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = source.QuoteIdent(col.Name)
+		types[i] = sqliteMirrorType(col.Type)
+	}
+	query := "SELECT " + strings.Join(names, ", ") + " FROM " + source.QuoteIdent(t.Table)
+	if t.Where != "" {
+		query += " WHERE " + t.Where
+	}
+
+	table := sqliteQuote(t.Table)
+	tmp := sqliteQuote("_judb_mirror_" + t.Table)
+	var defs []string
+	for i, col := range columns {
+		def := sqliteQuote(col.Name) + " " + types[i]
+		if !col.Nullable {
+			def += " NOT NULL"
+		}
+		defs = append(defs, def)
+	}
+	if len(primaryKey) > 0 {
+		defs = append(defs, "PRIMARY KEY ("+sqliteQuoteList(primaryKey)+")")
+	}
+	var createIndexes []string
+	for _, index := range indexes {
+		if index.Primary || !mirrorIndexColumns(index, columns) {
+			continue
+		}
+		statement := "CREATE INDEX "
+		if index.Unique {
+			statement = "CREATE UNIQUE INDEX "
+		}
+		// SQLite 的索引名在数据库中唯一, 加上表名避免和其它表的索引重名
+		name := sqliteQuote(t.Table + "_" + index.Name)
+		createIndexes = append(createIndexes, statement+name+" ON "+table+" ("+sqliteQuoteList(index.Columns)+")")
+	}
+	insert := "INSERT INTO " + tmp + " VALUES (" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+
+	ctx := context.Background()
+	err = mdb.writeStatements(ctx, "DROP TABLE IF EXISTS "+tmp, "CREATE TABLE "+tmp+" ("+strings.Join(defs, ", ")+")")
+	if err != nil {
+		return 0, err
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			_ = mdb.writeStatements(ctx, "DROP TABLE IF EXISTS "+tmp)
+		}
+	}()
+
+	var count int64
+	batch := make([][]interface{}, 0, mirrorBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := mdb.write(ctx, func(conn *sql.Conn) error {
+			tx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = tx.Rollback()
+			}()
+			stmt, err := tx.PrepareContext(ctx, insert)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = stmt.Close()
+			}()
+			for _, values := range batch {
+				if _, err = stmt.ExecContext(ctx, values...); err != nil {
+					return err
+				}
+			}
+			return tx.Commit()
+		})
+		count += int64(len(batch))
+		batch = batch[:0]
+		return err
+	}
+	err = source.query(source.Rebind(query), func(rows *sql.Rows) error {
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			pointers := make([]interface{}, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			if err := rows.Scan(pointers...); err != nil {
+				return err
+			}
+			for i, v := range values {
+				values[i] = mirrorValue(types[i], v)
+			}
+			batch = append(batch, values)
+			if len(batch) == mirrorBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return flush()
+	}, t.Args...)
+	if err != nil {
+		return 0, err
+	}
+
+	statements := []string{
+		"DROP TABLE IF EXISTS " + table,
+		"ALTER TABLE " + tmp + " RENAME TO " + table,
+	}
+	if err = mdb.writeStatements(ctx, append(statements, createIndexes...)...); err != nil {
+		return 0, err
+	}
+	swapped = true
+	return count, nil
+}
+
+// writeStatements 通过写队列在一个事务中依次执行 statements
+func (mdb *SQLiteMemDb) writeStatements(ctx context.Context, statements ...string) error {
+	return mdb.write(ctx, func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		for _, s := range statements {
+			if _, err = tx.ExecContext(ctx, s); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// stopMirror 停止定时刷新, Close 时调用
+func (mdb *SQLiteMemDb) stopMirror() {
+	m := mdb.mirror.Load()
+	if m == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	mdb.mirror.Store(nil)
+}
+
+// sqliteMirrorType 把源数据库的列类型转换为 SQLite 的类型
+func sqliteMirrorType(typ string) string {
+	typ = strings.ToLower(typ)
+	switch {
+	case isIntegerType(typ) || strings.HasPrefix(typ, "bool"):
+		return "INTEGER"
+	case strings.Contains(typ, "decimal") || strings.Contains(typ, "numeric"):
+		return "TEXT"
+	case strings.Contains(typ, "real") || strings.Contains(typ, "float") || strings.Contains(typ, "double"):
+		return "REAL"
+	case strings.Contains(typ, "timestamp") || strings.Contains(typ, "datetime"):
+		return "TIMESTAMP"
+	case typ == "date":
+		return "DATE"
+	case strings.Contains(typ, "blob") || strings.Contains(typ, "binary") || typ == "bytea":
+		return "BLOB"
+	}
+	return "TEXT"
+}
+
+// mirrorValue 转换源数据库返回的值, MySQL 驱动把文本返回为 []byte, 直接插入会保存为 BLOB
+func mirrorValue(typ string, v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil, int64, float64, bool, string, time.Time:
+		return v
+	case []byte:
+		if typ == "BLOB" {
+			return x
+		}
+		return string(x)
+	case int32:
+		return int64(x)
+	case float32:
+		return float64(x)
+	}
+	return fmt.Sprint(v)
+}
+
+// mirrorIndexColumns 索引的列是否都是表中的普通列, 表达式索引不会镜像
+func mirrorIndexColumns(index Index, columns []Column) bool {
+	for _, name := range index.Columns {
+		found := false
+		for _, col := range columns {
+			if col.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(index.Columns) > 0
+}
+
+func sqliteQuoteList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = sqliteQuote(name)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,163 @@
+package judb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openMirrorSource(t *testing.T) *Db {
+	t.Helper()
+	source := &Db{}
+	if !source.OpenSqlite3(filepath.Join(t.TempDir(), "source.db"), "") {
+		t.Fatal("open sqlite failed")
+	}
+	t.Cleanup(source.Close)
+	source.SetErrorReporter(SilentReporter{})
+	for _, s := range []string{
+		"CREATE TABLE products (id INTEGER PRIMARY KEY, name TEXT NOT NULL, price DECIMAL(10,2), active BOOLEAN)",
+		"CREATE UNIQUE INDEX products_name ON products (name)",
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT)",
+		"INSERT INTO orders (status) VALUES ('open'), ('closed'), ('open')",
+	} {
+		if mr := source.Exec(s); mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+	}
+	// 超过 mirrorBatchSize 的行分多批加载
+	tx, mr := source.Begin()
+	if mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	for i := 1; i <= mirrorBatchSize+100; i++ {
+		if _, err := tx.Exec("INSERT INTO products (name, price, active) VALUES (?, ?, ?)", fmt.Sprintf("p%d", i), "1.50", i%2 == 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func openMirror(t *testing.T, options MirrorOptions) *SQLiteMemDb {
+	t.Helper()
+	mdb := &SQLiteMemDb{Reporter: SilentReporter{}}
+	if !mdb.Open() {
+		t.Fatal("open memory database failed")
+	}
+	t.Cleanup(mdb.Close)
+	if err := mdb.StartMirror(options); err != nil {
+		t.Fatal(err)
+	}
+	return mdb
+}
+
+func TestMirrorRefresh(t *testing.T) {
+	source := openMirrorSource(t)
+	mdb := openMirror(t, MirrorOptions{Source: source, Tables: []MirrorTable{
+		{Table: "products", WriteThrough: true},
+		{Table: "orders", Where: "status = ?", Args: []interface{}{"open"}},
+	}})
+	total := mirrorBatchSize + 100
+	expectCount(t, mdb, "SELECT count(*) FROM products", total)
+	expectCount(t, mdb, "SELECT count(*) FROM orders", 2)
+	// 主键和索引一起镜像, DECIMAL 保存为 TEXT
+	expectCount(t, mdb, "SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = 'products_products_name'", 1)
+	expectCount(t, mdb, "SELECT count(*) FROM products WHERE typeof(price) = 'text' AND typeof(active) = 'integer'", total)
+
+	for _, s := range []string{
+		"DELETE FROM products WHERE id > 10",
+		"UPDATE products SET name = 'renamed' WHERE id = 1",
+		"INSERT INTO orders (status) VALUES ('open')",
+	} {
+		if mr := source.Exec(s); mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+	}
+	// 只刷新指定的表
+	if err := mdb.RefreshMirror("products"); err != nil {
+		t.Fatal(err)
+	}
+	expectCount(t, mdb, "SELECT count(*) FROM products", 10)
+	expectCount(t, mdb, "SELECT count(*) FROM products WHERE id = 1 AND name = 'renamed'", 1)
+	expectCount(t, mdb, "SELECT count(*) FROM orders", 2)
+	if err := mdb.RefreshMirror(); err != nil {
+		t.Fatal(err)
+	}
+	expectCount(t, mdb, "SELECT count(*) FROM orders", 3)
+	// 临时表在替换后不存在
+	expectCount(t, mdb, "SELECT count(*) FROM sqlite_master WHERE name LIKE '_judb_mirror_%'", 0)
+
+	status := mdb.MirrorStatus()
+	if len(status) != 2 || status[0].Table != "products" || status[0].Rows != 10 || status[1].Rows != 3 ||
+		status[0].LastRefresh.IsZero() || status[0].Errors != 0 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	// 刷新失败时保留原来的数据并记录错误
+	if mr := source.Exec("DROP TABLE orders"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	if err := mdb.RefreshMirror("orders"); err == nil {
+		t.Fatal("refreshing a dropped table succeeded")
+	}
+	expectCount(t, mdb, "SELECT count(*) FROM orders", 3)
+	if s := mdb.MirrorStatus()[1]; s.Errors != 1 || !strings.Contains(s.LastError, "orders") || s.Rows != 3 {
+		t.Fatalf("status after failure = %+v", s)
+	}
+	if err := mdb.RefreshMirror("missing"); err == nil {
+		t.Fatal("refreshing an unmirrored table succeeded")
+	}
+}
+
+func TestMirrorExec(t *testing.T) {
+	source := openMirrorSource(t)
+	mdb := openMirror(t, MirrorOptions{Source: source, Tables: []MirrorTable{
+		{Table: "products", WriteThrough: true},
+		{Table: "orders"},
+	}})
+	if mr := mdb.MirrorExec("products", "UPDATE products SET price = ? WHERE id = ?", "9.99", 1); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	var price string
+	if err := source.QueryRow("SELECT price FROM products WHERE id = 1").Scan(&price); err != nil || price != "9.99" {
+		t.Fatalf("source price = %q, %v", price, err)
+	}
+	expectCount(t, mdb, "SELECT count(*) FROM products WHERE id = 1 AND price = '9.99'", 1)
+
+	// 源数据库执行失败时内存数据库不变
+	if mr := mdb.MirrorExec("products", "UPDATE products SET name = NULL WHERE id = 1"); !mr.Fail() {
+		t.Fatal("NOT NULL violation accepted")
+	}
+	if mr := mdb.MirrorExec("orders", "DELETE FROM orders"); !mr.Fail() || !strings.Contains(mr.Error, "WriteThrough") {
+		t.Fatalf("MirrorExec on a read-only table = %+v", mr)
+	}
+	expectCount(t, mdb, "SELECT count(*) FROM orders", 3)
+}
+
+func TestMirrorInterval(t *testing.T) {
+	source := openMirrorSource(t)
+	mdb := openMirror(t, MirrorOptions{Source: source, Tables: []MirrorTable{{Table: "orders"}}, Interval: 10 * time.Millisecond})
+	if mr := source.Exec("INSERT INTO orders (status) VALUES ('new')"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var n int
+		if err := mdb.QueryRow("SELECT count(*) FROM orders").Scan(&n); err == nil && n == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("mirror not refreshed, status = %+v", mdb.MirrorStatus())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Close 停止定时刷新
+	mdb.Close()
+	if status := mdb.MirrorStatus(); status != nil {
+		t.Fatalf("status after Close = %+v", status)
+	}
+}
@@ -0,0 +1,176 @@
+package judb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PersistOptions SQLiteMemDb 自动保存的设置, Interval 和 Writes 至少设置一个, 两者都设置时任意一个满足就保存
+type PersistOptions struct {
+	File     string        // 保存的文件
+	Interval time.Duration // 每隔多长时间保存一次, 这段时间没有写入时不保存
+	Writes   int64         // 通过写队列写入多少次后保存
+	Backup   BackupOptions // 保存时使用的备份设置
+}
+
+// PersistStats 自动保存的统计
+type PersistStats struct {
+	Snapshots     int64         // 成功保存的次数
+	LastTime      time.Time     // 最后一次成功保存的时间
+	LastDuration  time.Duration // 最后一次成功保存的耗时
+	LastSize      int64         // 最后一次保存的文件大小
+	Errors        int64         // 保存失败的次数
+	LastError     string
+	LastErrorTime time.Time
+	PendingWrites int64 // 最后一次保存之后的写入次数
+}
+
+type memPersist struct {
+	options PersistOptions
+	writes  atomic.Int64
+	trigger chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	saving sync.Mutex // 同一时间只有一个保存
+	mu     sync.Mutex
+	stats  PersistStats
+}
+
+// StartPersist 开始自动保存, Close 时会再保存一次. 保存使用在线备份 API, 保存期间内存数据库可以读写, 说明见 SaveToFileContext.
+// 只有通过 SQLiteMemDb 的写队列执行的操作才会计入写入次数, 例如 Exec, Write 和 WriteTx.
+func (mdb *SQLiteMemDb) StartPersist(options PersistOptions) error {
+	if mdb.Db == nil {
+		return errors.New("内存数据库没有打开")
+	}
+	if options.File == "" {
+		return errors.New("没有设置保存的文件")
+	}
+	if options.Interval <= 0 && options.Writes <= 0 {
+		return errors.New("Interval 和 Writes 至少需要设置一个")
+	}
+	p := &memPersist{
+		options: options,
+		trigger: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if !mdb.persist.CompareAndSwap(nil, p) {
+		return errors.New("已经开始自动保存")
+	}
+	mdb.stopped.Store(nil)
+	go mdb.persistLoop(p)
+	return nil
+}
+
+// Persist 立即保存一次, 没有调用 StartPersist 时返回错误
+func (mdb *SQLiteMemDb) Persist() error {
+	p := mdb.persist.Load()
+	if p == nil {
+		return errors.New("没有开始自动保存")
+	}
+	return mdb.snapshot(p)
+}
+
+// PersistStats 返回自动保存的统计, 没有开始自动保存时返回零值. Close 之后返回停止的自动保存的统计,
+// 可以检查 Close 时最后一次保存是否成功, 直到再次调用 StartPersist
+func (mdb *SQLiteMemDb) PersistStats() PersistStats {
+	p := mdb.persist.Load()
+	if p == nil {
+		p = mdb.stopped.Load()
+	}
+	if p == nil {
+		return PersistStats{}
+	}
+	p.mu.Lock()
+	stats := p.stats
+	p.mu.Unlock()
+	stats.PendingWrites = p.writes.Load()
+	return stats
+}
+
+func (mdb *SQLiteMemDb) countWrite() {
+	p := mdb.persist.Load()
+	if p == nil {
+		return
+	}
+	if n := p.writes.Add(1); p.options.Writes > 0 && n >= p.options.Writes {
+		select {
+		case p.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (mdb *SQLiteMemDb) persistLoop(p *memPersist) {
+	defer close(p.done)
+	var tick <-chan time.Time
+	if p.options.Interval > 0 {
+		ticker := time.NewTicker(p.options.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-tick:
+			if p.writes.Load() == 0 {
+				continue
+			}
+		case <-p.trigger:
+			if p.writes.Load() < p.options.Writes {
+				continue
+			}
+		}
+		mdb.reportError(0, mdb.snapshot(p))
+	}
+}
+
+// snapshot 保存期间的写入仍然计入下一次保存
+func (mdb *SQLiteMemDb) snapshot(p *memPersist) error {
+	p.saving.Lock()
+	defer p.saving.Unlock()
+	writes := p.writes.Load()
+	start := time.Now()
+	err := mdb.SaveToFileContext(context.Background(), p.options.File, p.options.Backup)
+	var size int64
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(p.options.File); err == nil {
+			size = info.Size()
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.stats.Errors++
+		p.stats.LastError = err.Error()
+		p.stats.LastErrorTime = time.Now()
+		return err
+	}
+	p.writes.Add(-writes)
+	p.stats.Snapshots++
+	p.stats.LastTime = start
+	p.stats.LastDuration = time.Since(start)
+	p.stats.LastSize = size
+	return nil
+}
+
+// stopPersist 停止自动保存并保存最后一次, Close 时调用
+func (mdb *SQLiteMemDb) stopPersist() {
+	p := mdb.persist.Load()
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	mdb.reportError(0, mdb.snapshot(p))
+	mdb.stopped.Store(p)
+	mdb.persist.Store(nil)
+}
@@ -0,0 +1,99 @@
+package judb
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPersistStatsAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name      string
+		file      string
+		snapshots int64
+		errors    int64
+	}{
+		{name: "saved", file: filepath.Join(dir, "mem.db"), snapshots: 1},
+		{name: "failed", file: filepath.Join(dir, "missing", "mem.db"), errors: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mdb := SQLiteMemDb{Reporter: SilentReporter{}}
+			if !mdb.Open() {
+				t.Fatal("open memory database failed")
+			}
+			if err := mdb.StartPersist(PersistOptions{File: tt.file, Writes: 1000}); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []string{"CREATE TABLE t (v INTEGER)", "INSERT INTO t VALUES (1)"} {
+				if mr := mdb.Exec(s); mr.Fail() {
+					t.Fatal(mr.Error)
+				}
+			}
+			if stats := mdb.PersistStats(); stats.PendingWrites != 2 {
+				t.Fatalf("PendingWrites = %d, want 2", stats.PendingWrites)
+			}
+			mdb.Close()
+
+			// Close 时最后一次保存的结果在 Close 之后仍然可以读取
+			stats := mdb.PersistStats()
+			if stats.Snapshots != tt.snapshots || stats.Errors != tt.errors {
+				t.Fatalf("stats = %+v, want %d snapshots and %d errors", stats, tt.snapshots, tt.errors)
+			}
+			if tt.errors > 0 {
+				if stats.LastError == "" || stats.PendingWrites != 2 {
+					t.Fatalf("stats = %+v, want the error and 2 pending writes", stats)
+				}
+				return
+			}
+			if stats.LastError != "" || stats.PendingWrites != 0 || stats.LastSize == 0 {
+				t.Fatalf("stats = %+v", stats)
+			}
+			fdb, err := sql.Open("sqlite3", tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fdb.Close()
+			}()
+			var n int
+			if err = fdb.QueryRow("SELECT count(*) FROM t").Scan(&n); err != nil || n != 1 {
+				t.Fatalf("saved rows = %d, %v", n, err)
+			}
+		})
+	}
+}
+
+// TestStartPersistDuringWrites 写入已经在进行时开始自动保存, 使用 -race 运行时检查数据竞争
+func TestStartPersistDuringWrites(t *testing.T) {
+	mdb := SQLiteMemDb{Reporter: SilentReporter{}}
+	if !mdb.Open() {
+		t.Fatal("open memory database failed")
+	}
+	if mr := mdb.Exec("CREATE TABLE t (v INTEGER)"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				mdb.Exec("INSERT INTO t VALUES (?)", j)
+			}
+		}()
+	}
+	if err := mdb.StartPersist(PersistOptions{File: filepath.Join(t.TempDir(), "mem.db"), Writes: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.StartPersist(PersistOptions{File: filepath.Join(t.TempDir(), "other.db"), Writes: 1}); err == nil {
+		t.Fatal("second StartPersist succeeded")
+	}
+	wg.Wait()
+	mdb.Close()
+	if stats := mdb.PersistStats(); stats.Snapshots != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,139 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+type writeRequest struct {
+	ctx    context.Context
+	fn     func(conn *sql.Conn) error
+	result chan error
+}
+
+// memWriter 所有的写操作在同一个 goroutine 中使用同一个连接依次执行
+type memWriter struct {
+	mu     sync.RWMutex // 保护 queue 的关闭
+	closed bool
+	queue  chan writeRequest
+	done   chan struct{}
+}
+
+func (mdb *SQLiteMemDb) startWriter() {
+	w := &memWriter{queue: make(chan writeRequest, 64), done: make(chan struct{})}
+	mdb.writer = w
+	go func() {
+		defer close(w.done)
+		for req := range w.queue {
+			err := req.ctx.Err()
+			if err == nil {
+				err = req.fn(mdb.keeper)
+			}
+			req.result <- err
+		}
+	}()
+}
+
+// stopWriter 等待队列中的写操作完成后停止
+func (mdb *SQLiteMemDb) stopWriter() {
+	w := mdb.writer
+	w.mu.Lock()
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+	<-w.done
+}
+
+// write 把 fn 放入写队列, 等待执行完成并返回它的结果
+func (mdb *SQLiteMemDb) write(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	w := mdb.writer
+	if w == nil {
+		return errors.New("内存数据库没有打开")
+	}
+	req := writeRequest{ctx: ctx, fn: fn, result: make(chan error, 1)}
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return errors.New("内存数据库已经关闭")
+	}
+	select {
+	case w.queue <- req:
+	case <-ctx.Done():
+		w.mu.RUnlock()
+		return ctx.Err()
+	}
+	w.mu.RUnlock()
+	return <-req.result
+}
+
+// Exec 通过写队列执行语句, 可以在多个 goroutine 中同时调用. 开始自动保存后会计入写入次数
+func (mdb *SQLiteMemDb) Exec(sqlCase string, args ...interface{}) SqlResult {
+	var mr SqlResult
+	err := mdb.write(context.Background(), func(conn *sql.Conn) error {
+		rst, err := conn.ExecContext(context.Background(), sqlCase, args...)
+		mr.Result = rst
+		return err
+	})
+	if err != nil {
+		mr.SetError(err)
+		mdb.reportError(errSkip, err)
+		return mr
+	}
+	mdb.countWrite()
+	return mr
+}
+
+// Write 在写入连接上执行 fn, fn 中可以执行多条语句, 执行期间没有其它的写操作. fn 不能调用 SQLiteMemDb 的写函数, 否则会死锁.
+func (mdb *SQLiteMemDb) Write(fn func(conn *sql.Conn) error) error {
+	err := mdb.write(context.Background(), fn)
+	if err == nil {
+		mdb.countWrite()
+	}
+	return err
+}
+
+// WriteTx 在写入连接的事务中执行 fn, fn 返回错误时回滚, 否则提交
+func (mdb *SQLiteMemDb) WriteTx(fn func(tx *sql.Tx) error) error {
+	err := mdb.write(context.Background(), func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		if err = fn(tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
+	if err == nil {
+		mdb.countWrite()
+	}
+	return err
+}
+
+// Query 从连接池读取数据, 可以和写操作以及其它读取同时进行, 用法和 Db.Query 相同.
+// 读取不等待写入中的事务, 可能读到还没有提交的数据.
+func (mdb *SQLiteMemDb) Query(sqlCase string, qc QueryCall, args ...interface{}) SqlResult {
+	var mr SqlResult
+	if mdb.Db == nil {
+		mr.Code = "-1"
+		mr.Error = "内存数据库没有打开"
+		mdb.reportError(errSkip, errors.New(mr.Error))
+		return mr
+	}
+	rows, err := mdb.Db.Query(sqlCase, args...)
+	if mdb.reportError(errSkip, err) {
+		mr.SetError(err)
+		return mr
+	}
+	qc(rows)
+	_ = rows.Close()
+	return mr
+}
+
+// QueryRow 从连接池读取一行数据
+func (mdb *SQLiteMemDb) QueryRow(sqlCase string, args ...interface{}) *sql.Row {
+	return mdb.Db.QueryRow(sqlCase, args...)
+}
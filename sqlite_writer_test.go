@@ -0,0 +1,85 @@
+package judb
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSQLiteMemDbWriteQueue(t *testing.T) {
+	mdb := SQLiteMemDb{Reporter: SilentReporter{}}
+	if !mdb.Open() {
+		t.Fatal("open memory database failed")
+	}
+	defer mdb.Close()
+	for _, s := range []string{"CREATE TABLE counter (v INTEGER)", "INSERT INTO counter VALUES (0)", "CREATE TABLE log (n INTEGER)"} {
+		if mr := mdb.Exec(s); mr.Fail() {
+			t.Fatal(mr.Error)
+		}
+	}
+
+	const workers, rounds = 8, 25
+	var running, overlaps atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds*3)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				// 先读再写, 没有排队时并发的写入会丢失更新或者出现 table is locked
+				errs <- mdb.WriteTx(func(tx *sql.Tx) error {
+					if running.Add(1) > 1 {
+						overlaps.Add(1)
+					}
+					defer running.Add(-1)
+					var v int
+					if err := tx.QueryRow("SELECT v FROM counter").Scan(&v); err != nil {
+						return err
+					}
+					_, err := tx.Exec("UPDATE counter SET v = ?", v+1)
+					return err
+				})
+				if mr := mdb.Exec("INSERT INTO log VALUES (?)", i); mr.Fail() {
+					errs <- errors.New(mr.Error)
+				}
+				// 读取使用其它连接, 和写入同时进行
+				if mr := mdb.Query("SELECT count(*) FROM log", func(rows *sql.Rows) {
+					for rows.Next() {
+					}
+				}); mr.Fail() {
+					errs <- errors.New(mr.Error)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := overlaps.Load(); n != 0 {
+		t.Fatalf("%d writes ran at the same time as another write", n)
+	}
+	expectCount(t, &mdb, "SELECT v FROM counter", workers*rounds)
+	expectCount(t, &mdb, "SELECT count(*) FROM log", workers*rounds)
+}
+
+func TestSQLiteMemDbWriteAfterClose(t *testing.T) {
+	mdb := SQLiteMemDb{Reporter: SilentReporter{}}
+	if !mdb.Open() {
+		t.Fatal("open memory database failed")
+	}
+	if mr := mdb.Exec("CREATE TABLE t (v INTEGER)"); mr.Fail() {
+		t.Fatal(mr.Error)
+	}
+	mdb.Close()
+	if mr := mdb.Exec("INSERT INTO t VALUES (1)"); !mr.Fail() || !strings.Contains(mr.Error, "没有打开") {
+		t.Fatalf("Exec after Close = %+v", mr)
+	}
+}
@@ -0,0 +1,191 @@
+package judb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jsuserapp/ju"
+)
+
+// CertReloader 支持证书热更新的 TLS 配置. MakeTLSConfig 只在创建时读取一次证书, 证书轮换后连接池新建的连接
+// 会失败, 直到进程重启. CertReloader 在每次 TLS 握手时检查证书文件的修改时间和大小, 文件变化后自动重新加载.
+// 重新加载失败时继续使用上一次成功加载的证书, 并通过 onError 回调报告错误, 文件再次变化后重新尝试加载.
+type CertReloader struct {
+	clientKeyPath  string
+	clientCertPath string
+	caCertPath     string
+	serverName     string
+	onError        func(err error)
+
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	rootPool *x509.CertPool
+	stamps   [3]fileStamp
+	failed   [3]fileStamp // 上一次加载失败时的文件状态, 文件没有再次变化前不重复加载
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertReloader 创建证书热更新对象, 首次加载失败时返回 nil.
+//
+// serverName: 服务器证书上的主机名或者 IP, 不能为空. TLSConfig 设置了 InsecureSkipVerify, 驱动不会再填写 ServerName,
+// 主机名只能由 VerifyConnection 按这个值校验, 为空时返回 nil.
+//
+// onError: 证书重新加载失败时的回调, 可以为 nil, 此时错误通过 JuConsoleReporter 输出到控制台. 回调不应该阻塞, 它在 TLS 握手过程中被调用.
+func NewCertReloader(clientKeyPath, clientCertPath, caCertPath, serverName string, onError func(err error)) *CertReloader {
+	if serverName == "" {
+		ju.OutputErrorTrace(errors.New("serverName 不能为空, 没有主机名时不能校验服务器证书"), 1)
+		return nil
+	}
+	r := &CertReloader{
+		clientKeyPath:  clientKeyPath,
+		clientCertPath: clientCertPath,
+		caCertPath:     caCertPath,
+		serverName:     serverName,
+		onError:        onError,
+	}
+	if ju.OutputErrorTrace(r.Reload(), 1) {
+		return nil
+	}
+	return r
+}
+
+// Reload 强制重新加载证书, 失败时保留原来的证书
+func (r *CertReloader) Reload() error {
+	stamps, err := r.readStamps()
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.load(stamps)
+}
+
+// TLSConfig 返回使用热更新证书的 tls.Config, 可以用于 mysql.RegisterTLSConfig 和 postgres.Config 的 TLSConfig 字段.
+// 服务器证书由 VerifyConnection 使用最新加载的 CA 和 NewCertReloader 的 serverName 校验, 所以 InsecureSkipVerify
+// 被设置为 true, 这并不会跳过校验.
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:           r.serverName,
+		InsecureSkipVerify:   true,
+		GetClientCertificate: r.GetClientCertificate,
+		VerifyConnection:     r.VerifyConnection,
+	}
+}
+
+// GetClientCertificate 用于 tls.Config 的同名回调, 返回当前的客户端证书
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.refresh()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.cert, nil
+}
+
+// VerifyConnection 用于 tls.Config 的同名回调, 使用当前的 CA 证书校验服务器证书链和主机名
+func (r *CertReloader) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("服务器没有提供证书")
+	}
+	r.refresh()
+	r.mutex.Lock()
+	pool := r.rootPool
+	r.mutex.Unlock()
+
+	// 不使用 cs.ServerName, InsecureSkipVerify 时驱动可能不设置它, 为空时 Verify 不检查主机名
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       r.serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// refresh 检查证书文件是否变化, 变化时重新加载, 错误交给 onError 处理
+func (r *CertReloader) refresh() {
+	stamps, err := r.readStamps()
+	if err == nil {
+		r.mutex.Lock()
+		if stamps != r.stamps && stamps != r.failed {
+			err = r.load(stamps)
+			if err != nil {
+				r.failed = stamps
+			}
+		}
+		r.mutex.Unlock()
+	}
+	if err == nil {
+		return
+	}
+	if r.onError != nil {
+		r.onError(err)
+	} else {
+		JuConsoleReporter{}.Report(0, fmt.Errorf("证书重新加载失败: %w", err))
+	}
+}
+
+func (r *CertReloader) readStamps() (stamps [3]fileStamp, err error) {
+	for i, path := range []string{r.clientKeyPath, r.clientCertPath, r.caCertPath} {
+		info, e := os.Stat(path)
+		if e != nil {
+			return stamps, e
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+// load 调用者需要持有 mutex
+func (r *CertReloader) load(stamps [3]fileStamp) error {
+	caCert, err := os.ReadFile(r.caCertPath)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return fmt.Errorf("添加 CA 证书到证书池失败: %s", r.caCertPath)
+	}
+	cert, err := tls.LoadX509KeyPair(r.clientCertPath, r.clientKeyPath)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.rootPool = pool
+	r.stamps = stamps
+	return nil
+}
+
+// MakeMysqlReloadSSLConfig 和 MakeMysqlSSLConfig 相同, 但是使用 CertReloader 提供的证书, 证书文件更新后新建的连接
+// 会使用新的证书. 注册失败时返回 nil.
+func MakeMysqlReloadSSLConfig(host, port, dbname, user, pass, tlsName string, reloader *CertReloader) *mysql.Config {
+	if reloader == nil {
+		return nil
+	}
+	if tlsName == "" {
+		tlsName = "judb-tls-config"
+	}
+	err := mysql.RegisterTLSConfig(tlsName, reloader.TLSConfig())
+	if ju.LogFail(err) {
+		ju.LogRed(fmt.Sprintf("注册自定义 TLS 配置失败: %v", err))
+		return nil
+	}
+	// 和 MakeMysqlSSLConfig 一样使用 UTC 时区
+	cfg := MakeMysqlConfig(host, port, dbname, user, pass)
+	cfg.Loc = time.UTC
+	cfg.Params = map[string]string{
+		"tls": tlsName,
+	}
+	return cfg
+}
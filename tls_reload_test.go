@@ -0,0 +1,250 @@
+package judb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 测试用的证书颁发机构
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 签发证书, 返回证书和私钥的 PEM
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), cert
+}
+
+// writeChanged 写入文件并把修改时间设置为 at, 文件系统的时间精度较低时, 仍然可以保证 CertReloader 看到变化
+func writeChanged(t *testing.T, path string, data []byte, at time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type reloadFiles struct {
+	key, cert, ca string
+}
+
+func writeClientFiles(t *testing.T, dir string, ca *testCA, serial int64, at time.Time) reloadFiles {
+	t.Helper()
+	files := reloadFiles{
+		key:  filepath.Join(dir, "client.key"),
+		cert: filepath.Join(dir, "client.crt"),
+		ca:   filepath.Join(dir, "ca.crt"),
+	}
+	certPEM, keyPEM, _ := ca.issue(t, "client", serial, x509.ExtKeyUsageClientAuth)
+	writeChanged(t, files.key, keyPEM, at)
+	writeChanged(t, files.cert, certPEM, at)
+	writeChanged(t, files.ca, ca.pem, at)
+	return files
+}
+
+func clientSerial(t *testing.T, r *CertReloader) int64 {
+	t.Helper()
+	cert, err := r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func serverState(t *testing.T, ca *testCA, name string) tls.ConnectionState {
+	t.Helper()
+	_, _, cert := ca.issue(t, name, 100, x509.ExtKeyUsageServerAuth)
+	return tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+}
+
+func TestCertReloaderRequiresServerName(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	files := writeClientFiles(t, t.TempDir(), ca, 2, time.Now())
+	if r := NewCertReloader(files.key, files.cert, files.ca, "", nil); r != nil {
+		t.Fatal("NewCertReloader accepted an empty server name")
+	}
+}
+
+func TestCertReloaderVerifyHostname(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	files := writeClientFiles(t, t.TempDir(), ca, 2, time.Now())
+	r := NewCertReloader(files.key, files.cert, files.ca, "db.example.com", nil)
+	if r == nil {
+		t.Fatal("NewCertReloader failed")
+	}
+	if err := r.VerifyConnection(serverState(t, ca, "db.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	// 驱动传入的 ServerName 不影响校验
+	state := serverState(t, ca, "other.example.com")
+	state.ServerName = "other.example.com"
+	if err := r.VerifyConnection(state); err == nil {
+		t.Fatal("certificate for another host accepted")
+	}
+	if err := r.VerifyConnection(serverState(t, newTestCA(t, "other ca"), "db.example.com")); err == nil {
+		t.Fatal("certificate from an unknown CA accepted")
+	}
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Now().Add(-time.Minute)
+	ca := newTestCA(t, "ca")
+	files := writeClientFiles(t, dir, ca, 2, at)
+	var errs []error
+	r := NewCertReloader(files.key, files.cert, files.ca, "db.example.com", func(err error) {
+		errs = append(errs, err)
+	})
+	if r == nil {
+		t.Fatal("NewCertReloader failed")
+	}
+	if serial := clientSerial(t, r); serial != 2 {
+		t.Fatalf("serial = %d, want 2", serial)
+	}
+
+	// 证书和私钥更新后, 下一次握手使用新的证书
+	at = at.Add(time.Second)
+	writeClientFiles(t, dir, ca, 3, at)
+	if serial := clientSerial(t, r); serial != 3 {
+		t.Fatalf("serial after cert change = %d, want 3", serial)
+	}
+
+	// CA 更新后, 旧 CA 签发的服务器证书不再被接受
+	at = at.Add(time.Second)
+	newCA := newTestCA(t, "new ca")
+	writeChanged(t, files.ca, newCA.pem, at)
+	if err := r.VerifyConnection(serverState(t, ca, "db.example.com")); err == nil {
+		t.Fatal("server certificate from the old CA accepted after CA change")
+	}
+	if err := r.VerifyConnection(serverState(t, newCA, "db.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("unexpected reload errors: %v", errs)
+	}
+}
+
+func TestCertReloaderKeepsLastGood(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Now().Add(-time.Minute)
+	ca := newTestCA(t, "ca")
+	files := writeClientFiles(t, dir, ca, 2, at)
+	var errs []error
+	r := NewCertReloader(files.key, files.cert, files.ca, "db.example.com", func(err error) {
+		errs = append(errs, err)
+	})
+	if r == nil {
+		t.Fatal("NewCertReloader failed")
+	}
+
+	// 只更新了证书, 私钥还是旧的, 加载失败
+	at = at.Add(time.Second)
+	certPEM, _, _ := ca.issue(t, "client", 3, x509.ExtKeyUsageClientAuth)
+	writeChanged(t, files.cert, certPEM, at)
+	if serial := clientSerial(t, r); serial != 2 {
+		t.Fatalf("serial after failed reload = %d, want 2", serial)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("errors = %v, want one", errs)
+	}
+	// 文件没有再次变化时不重复加载, 也不重复报告
+	if serial := clientSerial(t, r); serial != 2 {
+		t.Fatalf("serial = %d, want 2", serial)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("errors = %v, want one", errs)
+	}
+	if err := r.VerifyConnection(serverState(t, ca, "db.example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 无效的 CA 同样保留原来的证书池
+	at = at.Add(time.Second)
+	writeChanged(t, files.ca, []byte("not a certificate"), at)
+	if err := r.VerifyConnection(serverState(t, ca, "db.example.com")); err != nil {
+		t.Fatalf("verify after failed CA reload: %v", err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("errors = %v, want two", errs)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload with an invalid CA succeeded")
+	}
+
+	// 文件修复后恢复加载
+	at = at.Add(time.Second)
+	writeClientFiles(t, dir, ca, 4, at)
+	if serial := clientSerial(t, r); serial != 4 {
+		t.Fatalf("serial after fix = %d, want 4", serial)
+	}
+}
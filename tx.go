@@ -0,0 +1,83 @@
+package judb
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Tx 由 Db.BeginHooked 返回的事务, 嵌入了 *sql.Tx, 用法和 sql.Tx 相同. 区别是 Exec, Query, QueryRow, Commit 和 Rollback
+// 会调用 Db 上注册的 Hook. 通过 Prepare 和 Stmt 执行的语句不会调用 Hook. Query 的 After 在读取数据之前调用, 见 QueryContext.
+type Tx struct {
+	*sql.Tx
+	db   *Db
+	ctx  context.Context // BeginHooked 时 Hook 返回的 context, 作为不带 context 的调用的父 context
+	done bool
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(tx.ctx, query, args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, event := tx.db.beforeHooks(ctx, OpExec, query, args, true)
+	rst, err := tx.Tx.ExecContext(ctx, event.SQL, event.Args...)
+	if err == nil {
+		if count, e := rst.RowsAffected(); e == nil {
+			event.RowsAffected = count
+		}
+	}
+	mr := SqlResult{Result: rst}
+	mr.SetError(err)
+	tx.db.afterHooks(ctx, event, &mr)
+	return rst, err
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.QueryContext(tx.ctx, query, args...)
+}
+
+// QueryContext 返回的是 *sql.Rows, 读取和关闭由调用者控制, 所以 After 在返回之前调用, 这时还没有读取任何一行.
+// 和 Db 的 Query 不同, event.Duration 只包含执行语句的时间, 不包含读取数据的时间, 读取过程中的错误, 也就是 rows.Err
+// 和 rows.Scan 返回的错误, 不会传给 After. QueryRow 也一样, After 在 Scan 之前调用. SQLite 在读取第一行时才执行语句,
+// 所以耗时可能接近 0. 需要统计读取时间时, 在事务外使用 Db 的 Query
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, event := tx.db.beforeHooks(ctx, OpQuery, query, args, true)
+	rows, err := tx.Tx.QueryContext(ctx, event.SQL, event.Args...)
+	mr := NewSqlResult(err)
+	tx.db.afterHooks(ctx, event, &mr)
+	return rows, err
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.QueryRowContext(tx.ctx, query, args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, event := tx.db.beforeHooks(ctx, OpQueryRow, query, args, true)
+	row := tx.Tx.QueryRowContext(ctx, event.SQL, event.Args...)
+	mr := NewSqlResult(row.Err())
+	tx.db.afterHooks(ctx, event, &mr)
+	return row
+}
+
+func (tx *Tx) Commit() error {
+	return tx.finish(OpCommit, tx.Tx.Commit)
+}
+
+// Rollback 事务已经结束时返回 sql.ErrTxDone, 此时不调用 Hook, 所以可以放心的使用 defer tx.Rollback()
+// Tx 和 sql.Tx 一样不支持并发的 Commit 和 Rollback
+func (tx *Tx) Rollback() error {
+	return tx.finish(OpRollback, tx.Tx.Rollback)
+}
+
+func (tx *Tx) finish(op string, fn func() error) error {
+	if tx.done {
+		return fn()
+	}
+	tx.done = true
+	ctx, event := tx.db.beforeHooks(tx.ctx, op, "", nil, true)
+	err := fn()
+	mr := NewSqlResult(err)
+	tx.db.afterHooks(ctx, event, &mr)
+	return err
+}